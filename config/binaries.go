@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+// configs of binaries. Every binary loads its config and Common from the same file
+
+// settings of all binaries, all are optional.
+// Logs levels are DBG, INF, WRN or ERR, DBG if not set
+type Common struct {
+	LogsServerNetwork string
+	LogsServerAddr    string
+	LogsSpoolPath     string
+	LogsServerLevel   string
+	LogsLevelsPath    string
+
+	LogsFilePath        string // plain text logs are written into it, if set
+	LogsFileLevel       string
+	LogsFileMaxSizeMB   int // file is rotated by size, if set
+	LogsFileRotateHours int // file is rotated by time, if set
+	LogsFileKeep        int // number of kept rotated files, all are kept if not set
+	LogsFileKeepDays    int // older rotated files are removed, if set
+
+	LogsJSON      bool // newline-delimited json logs on stdout
+	LogsJSONLevel string
+
+	MetricsAddr string
+
+	ManifestPath   string // provenance of files is tracked, if set
+	QuarantinePath string // failed files are moved into stages' dirs in it, if set
+}
+
+func (c *Common) Validate() error {
+	if c.LogsServerAddr != "" && c.LogsSpoolPath == "" {
+		return Invalid("LogsSpoolPath", "must be specified with LogsServerAddr")
+	}
+	for field, lvl := range map[string]string{"LogsServerLevel": c.LogsServerLevel, "LogsFileLevel": c.LogsFileLevel, "LogsJSONLevel": c.LogsJSONLevel} {
+		if _, ok := logsLevel(lvl); !ok {
+			return Invalid(field, "unknown level "+lvl)
+		}
+	}
+	if c.LogsFileMaxSizeMB < 0 || c.LogsFileRotateHours < 0 || c.LogsFileKeep < 0 || c.LogsFileKeepDays < 0 {
+		return Invalid("LogsFile", "rotation settings must not be negative")
+	}
+	if c.LogsFilePath == "" && (c.LogsFileMaxSizeMB != 0 || c.LogsFileRotateHours != 0 || c.LogsFileKeep != 0 || c.LogsFileKeepDays != 0) {
+		return Invalid("LogsFilePath", "must be specified with rotation settings")
+	}
+	return nil
+}
+
+// remote, file and json sinks, that are set in c. Common must be validated
+func (c *Common) LogsSinks() ([]logger.LeveledSink, error) {
+	var sinks []logger.LeveledSink
+	fail := func(err error) ([]logger.LeveledSink, error) {
+		for _, s := range sinks {
+			s.Close()
+		}
+		return nil, err
+	}
+	if c.LogsServerAddr != "" {
+		rs, err := logger.NewRemoteSink(c.LogsServerNetwork, c.LogsServerAddr, c.LogsSpoolPath)
+		if err != nil {
+			return fail(errors.New("create remote logs sink err: " + err.Error()))
+		}
+		lvl, _ := logsLevel(c.LogsServerLevel)
+		sinks = append(sinks, logger.WithLevel(rs, lvl))
+	}
+	if c.LogsFilePath != "" {
+		var fs logger.Sink
+		var err error
+		opts := logger.RotateOptions{
+			MaxSize:    int64(c.LogsFileMaxSizeMB) << 20,
+			Interval:   time.Duration(c.LogsFileRotateHours) * time.Hour,
+			MaxBackups: c.LogsFileKeep,
+			MaxAge:     time.Duration(c.LogsFileKeepDays) * time.Hour * 24,
+		}
+		if opts == (logger.RotateOptions{}) {
+			fs, err = logger.NewFileSink(c.LogsFilePath)
+		} else {
+			fs, err = logger.NewRotatingFileSink(c.LogsFilePath, opts)
+		}
+		if err != nil {
+			return fail(errors.New("create file logs sink err: " + err.Error()))
+		}
+		lvl, _ := logsLevel(c.LogsFileLevel)
+		sinks = append(sinks, logger.WithLevel(fs, lvl))
+	}
+	if c.LogsJSON {
+		lvl, _ := logsLevel(c.LogsJSONLevel)
+		sinks = append(sinks, logger.WithLevel(logger.NewJSONSink(), lvl))
+	}
+	return sinks, nil
+}
+
+// DebugLevel for empty string
+func logsLevel(s string) (encode.LogsFlushLevel, bool) {
+	if s == "" {
+		return encode.DebugLevel, true
+	}
+	lt, ok := encode.ParseLogType(strings.ToUpper(strings.TrimSpace(s)))
+	return encode.LogsFlushLevel(lt), ok
+}
+
+type Emailer struct {
+	DownloadsPath      string `conf:"required,dir"`
+	TimerSeconds       int    `conf:"required"`
+	SuppliersConfsPath string `conf:"required,dir"`
+	ImapAccountsPath   string `conf:"required,dir"`
+}
+
+type Unzipper struct {
+	ZipPath      string `conf:"required,dir"`
+	CsvPath      string `conf:"required,dir"`
+	TimerSeconds int    `conf:"required"`
+
+	ShittyCharsetZipNamesPrefixes []string
+	ShittyCharsets                []string
+}
+
+func (c *Unzipper) Validate() error {
+	return validateShittyCharsets(c.ShittyCharsetZipNamesPrefixes, c.ShittyCharsets)
+}
+
+type Csvformatter struct {
+	RawCsvPath   string `conf:"required,dir"`
+	CsvPath      string `conf:"required,dir"`
+	TimerSeconds int    `conf:"required"`
+
+	SuppliersConfsPath         string `conf:"required,dir"`
+	SuppliersCsvFormatFilePath string `conf:"required"`
+}
+
+type Data2db struct {
+	ProductsCsvPath             string `conf:"required,dir"`
+	SuppliersConfsPath          string `conf:"required,dir"`
+	BrandsFilePath              string `conf:"required"`
+	AlternativeArticulsFilePath string `conf:"required"`
+	SuppliersCsvFormatFilePath  string `conf:"required"`
+	CategoriesFilePath          string `conf:"required"`
+
+	TimerSeconds int `conf:"required"`
+
+	DSN string `conf:"required"` // postgres, is better set with env var or secret file
+}
+
+type Pipeline struct {
+	DownloadsPath   string `conf:"required,dir"` // emailer's downloads and unzipper's zips
+	RawCsvPath      string `conf:"required,dir"`
+	ProductsCsvPath string `conf:"required,dir"`
+	TimerSeconds    int    `conf:"required"` // fetch interval and fallback interval of other stages
+
+	SuppliersConfsPath          string `conf:"required,dir"`
+	SuppliersCsvFormatFilePath  string `conf:"required"`
+	AlternativeArticulsFilePath string `conf:"required"`
+	ImapAccountsPath            string `conf:"required,dir"`
+
+	ShittyCharsetZipNamesPrefixes []string
+	ShittyCharsets                []string
+
+	DSN string `conf:"required"` // postgres, is better set with env var or secret file
+}
+
+func (c *Pipeline) Validate() error {
+	return validateShittyCharsets(c.ShittyCharsetZipNamesPrefixes, c.ShittyCharsets)
+}
+
+func validateShittyCharsets(prefixes, charsets []string) error {
+	if len(prefixes) != len(charsets) {
+		return Invalid("ShittyCharsets", "length mismatch with ShittyCharsetZipNamesPrefixes")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/okonma-violet/spec/logs/encode"
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+func TestCommonLogsSinks(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		common Common
+		field  string // of invalid one
+		levels []encode.LogsFlushLevel
+	}{
+		{"none", Common{}, "", nil},
+		{"file", Common{LogsFilePath: filepath.Join(dir, "a.txt"), LogsFileLevel: "inf"}, "", []encode.LogsFlushLevel{encode.InfoLevel}},
+		{"rotating file and json", Common{LogsFilePath: filepath.Join(dir, "b.txt"), LogsFileMaxSizeMB: 1, LogsJSON: true, LogsJSONLevel: "WRN"}, "", []encode.LogsFlushLevel{encode.DebugLevel, encode.WarningLevel}},
+		{"unknown level", Common{LogsJSON: true, LogsJSONLevel: "TRACE"}, "LogsJSONLevel", nil},
+		{"rotation without file", Common{LogsFileKeep: 3}, "LogsFilePath", nil},
+		{"negative rotation", Common{LogsFilePath: filepath.Join(dir, "c.txt"), LogsFileKeepDays: -1}, "LogsFile", nil},
+		{"server without spool", Common{LogsServerAddr: "127.0.0.1:7070"}, "LogsSpoolPath", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.common.Validate()
+			if tt.field != "" {
+				if cerr, ok := err.(*Error); !ok || cerr.Field != tt.field {
+					t.Fatalf("Validate() = %v, want invalid %s", err, tt.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sinks, err := tt.common.LogsSinks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				for _, s := range sinks {
+					s.Close()
+				}
+			}()
+			if len(sinks) != len(tt.levels) {
+				t.Fatalf("%d sinks, want %d", len(sinks), len(tt.levels))
+			}
+			for i, s := range sinks {
+				if s.Level != tt.levels[i] {
+					t.Fatalf("sink %d level %d, want %d", i, s.Level, tt.levels[i])
+				}
+			}
+			if tt.common.LogsFileMaxSizeMB > 0 {
+				if _, ok := sinks[0].Sink.(*logger.RotatingFileSink); !ok {
+					t.Fatalf("file sink is %T, want rotating one", sinks[0].Sink)
+				}
+			}
+		})
+	}
+}
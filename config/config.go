@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/okonma-violet/confdecoder"
+)
+
+// typed configs of binaries. Files are decoded with confdecoder, then fields are overridden
+// with env vars and validated.
+// Env var of field is PREFIX_FIELD_NAME (e.g. UNZIPPER_ZIP_PATH for ZipPath), slices are comma separated.
+// PREFIX_FIELD_NAME_FILE is a path of file with value (e.g. mounted secret), trailing newline is trimmed.
+// Field's tag `conf:"required,dir"`: required field must not be zero, dir path gets trailing slash.
+// Config may implement Validate() for checks of several fields
+
+// names the file (or env var) and the field, that is wrong
+type Error struct {
+	File   string
+	Field  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return "config " + e.File + ": " + e.Reason
+	}
+	return "config " + e.File + ": " + e.Field + ": " + e.Reason
+}
+
+// for Validate methods, file is set by Load
+func Invalid(field, reason string) error {
+	return &Error{Field: field, Reason: reason}
+}
+
+type validator interface {
+	Validate() error
+}
+
+// decodes file into every of vs (pointers to structs), overrides them with env vars
+// (no overrides with empty envprefix) and validates
+func Load(path, envprefix string, vs ...interface{}) error {
+	for _, v := range vs {
+		if err := confdecoder.DecodeFile(path, v); err != nil {
+			return &Error{File: path, Reason: err.Error()}
+		}
+		if envprefix != "" {
+			if err := override(envprefix, v); err != nil {
+				return err
+			}
+		}
+		if err := check(path, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func override(envprefix string, v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := EnvName(envprefix, sf.Name)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			path, ok := os.LookupEnv(name + "_FILE")
+			if !ok {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return &Error{File: "env " + name + "_FILE", Field: sf.Name, Reason: err.Error()}
+			}
+			val = strings.TrimRight(string(data), "\r\n")
+		}
+		if err := set(rv.Field(i), val); err != nil {
+			return &Error{File: "env " + name, Field: sf.Name, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// PREFIX_FIELD_NAME
+func EnvName(envprefix, field string) string {
+	var b strings.Builder
+	b.WriteString(envprefix)
+	b.WriteByte('_')
+	rs := []rune(field)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) && rs[i-1] != '_' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func set(f reflect.Value, val string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Slice:
+		var parts []string
+		if val != "" {
+			parts = strings.Split(val, ",")
+		}
+		s := reflect.MakeSlice(f.Type(), len(parts), len(parts))
+		for i := range parts {
+			if err := set(s.Index(i), strings.TrimSpace(parts[i])); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+	default:
+		return errors.New("unsupported type " + f.Type().String())
+	}
+	return nil
+}
+
+func check(path string, v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := sf.Tag.Lookup("conf")
+		if !ok {
+			continue
+		}
+		f := rv.Field(i)
+		for _, opt := range strings.Split(tag, ",") {
+			switch opt {
+			case "required":
+				if f.IsZero() || (f.Kind() == reflect.Slice && f.Len() == 0) {
+					return &Error{File: path, Field: sf.Name, Reason: "not specified or is zero"}
+				}
+			case "dir":
+				if f.Kind() == reflect.String && f.String() != "" && !strings.HasSuffix(f.String(), "/") {
+					f.SetString(f.String() + "/")
+				}
+			}
+		}
+	}
+	if vl, ok := v.(validator); ok {
+		if err := vl.Validate(); err != nil {
+			var cerr *Error
+			if errors.As(err, &cerr) {
+				if cerr.File == "" {
+					cerr.File = path
+				}
+				return cerr
+			}
+			return &Error{File: path, Reason: err.Error()}
+		}
+	}
+	return nil
+}
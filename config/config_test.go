@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		field, want string
+	}{
+		{"ZipPath", "UNZIPPER_ZIP_PATH"},
+		{"DSN", "UNZIPPER_DSN"},
+		{"PostgresDSN", "UNZIPPER_POSTGRES_DSN"},
+		{"ImapAddr", "UNZIPPER_IMAP_ADDR"},
+		{"MailFileNamePattern_Prefixes", "UNZIPPER_MAIL_FILE_NAME_PATTERN_PREFIXES"},
+		{"TimerSeconds2", "UNZIPPER_TIMER_SECONDS2"},
+		{"LogsFileMaxSizeMB", "UNZIPPER_LOGS_FILE_MAX_SIZE_MB"},
+	}
+	for _, tt := range tests {
+		if got := EnvName("UNZIPPER", tt.field); got != tt.want {
+			t.Errorf("EnvName(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+type testConfig struct {
+	Path     string `conf:"required,dir"`
+	Password string
+	Timer    int
+	Watch    bool
+	Names    []string
+	Cols     []int
+	hidden   string // not overridden
+}
+
+func TestOverride(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    testConfig
+		wanterr string // invalid field
+	}{
+		{"no env", nil, testConfig{Path: "file/", Timer: 5}, ""},
+		{"values", map[string]string{"T_PATH": "env", "T_TIMER": " 10", "T_WATCH": "true", "T_NAMES": "a, b", "T_COLS": "1,2", "T_HIDDEN": "x"},
+			testConfig{Path: "env", Timer: 10, Watch: true, Names: []string{"a", "b"}, Cols: []int{1, 2}}, ""},
+		{"empty slice", map[string]string{"T_NAMES": ""}, testConfig{Path: "file/", Timer: 5, Names: []string{}}, ""},
+		{"secret file", map[string]string{"T_PASSWORD_FILE": secret}, testConfig{Path: "file/", Password: "s3cr3t", Timer: 5}, ""},
+		{"env over secret file", map[string]string{"T_PASSWORD": "env", "T_PASSWORD_FILE": secret}, testConfig{Path: "file/", Password: "env", Timer: 5}, ""},
+		{"missing secret file", map[string]string{"T_PASSWORD_FILE": secret + ".missing"}, testConfig{}, "Password"},
+		{"bad int", map[string]string{"T_TIMER": "ten"}, testConfig{}, "Timer"},
+		{"bad int in slice", map[string]string{"T_COLS": "1,x"}, testConfig{}, "Cols"},
+		{"bad bool", map[string]string{"T_WATCH": "yes please"}, testConfig{}, "Watch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			conf := &testConfig{Path: "file/", Timer: 5}
+			err := override("T", conf)
+			if tt.wanterr != "" {
+				if cerr, ok := err.(*Error); !ok || cerr.Field != tt.wanterr {
+					t.Fatalf("override() = %v, want invalid %s", err, tt.wanterr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*conf, tt.want) {
+				t.Fatalf("overridden %+v, want %+v", *conf, tt.want)
+			}
+		})
+	}
+}
+
+func (c *testConfig) Validate() error {
+	if c.Timer < 0 {
+		return Invalid("Timer", "negative")
+	}
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    testConfig
+		want    string // path after check
+		wanterr string // invalid field
+	}{
+		{"dir gets slash", testConfig{Path: "dir"}, "dir/", ""},
+		{"dir with slash", testConfig{Path: "dir/"}, "dir/", ""},
+		{"required", testConfig{}, "", "Path"},
+		{"validated", testConfig{Path: "dir", Timer: -1}, "", "Timer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := check("config.txt", &tt.conf)
+			if tt.wanterr != "" {
+				cerr, ok := err.(*Error)
+				if !ok || cerr.Field != tt.wanterr || cerr.File != "config.txt" {
+					t.Fatalf("check() = %v, want invalid %s in config.txt", err, tt.wanterr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.conf.Path != tt.want {
+				t.Fatalf("path %q, want %q", tt.conf.Path, tt.want)
+			}
+		})
+	}
+}
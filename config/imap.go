@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// mail account's config file in imap accounts dir, that suppliers' prices are fetched from.
+// Account is named after its file without .txt, its fields may be overridden with env vars
+// PREFIX_NAME_FIELD_NAME (e.g. EMAILER_MAILRU_IMAP_PASSWORD_FILE for mailru.txt), so login
+// and password are better set with env vars or secret files
+type ImapAccount struct {
+	ImapAddr      string   `conf:"required"` // host:port, TLS
+	ImapMailboxes []string `conf:"required"` // folders
+	ImapLogin     string   `conf:"required"`
+	ImapPassword  string   `conf:"required"`
+
+	ImapUnmatchedMailbox string // messages of suppliers without suitable attachments are moved into, if set
+	ImapBackfill         bool   // mailbox without cursor is fetched from its first message, otherwise only new messages are
+
+	Name string // is not decoded
+}
+
+func (a *ImapAccount) Validate() error {
+	for i := range a.ImapMailboxes {
+		if a.ImapMailboxes[i] == "" {
+			return Invalid("ImapMailboxes", "empty mailbox name")
+		}
+		for k := 0; k < i; k++ {
+			if a.ImapMailboxes[k] == a.ImapMailboxes[i] {
+				return Invalid("ImapMailboxes", "duplicated mailbox "+a.ImapMailboxes[i])
+			}
+		}
+	}
+	return nil
+}
+
+// loads all .txt files from dir, sorted by names. Names may contain only latin letters, digits and underscores
+func LoadImapAccounts(dirpath, envprefix string) ([]*ImapAccount, error) {
+	files, err := os.ReadDir(dirpath)
+	if err != nil {
+		return nil, err
+	}
+	accs := make([]*ImapAccount, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".txt") {
+			continue
+		}
+		path := dirpath + f.Name()
+		name := strings.TrimSuffix(f.Name(), ".txt")
+		if !validAccountName(name) {
+			return nil, &Error{File: path, Reason: "account name may contain only latin letters, digits and underscores"}
+		}
+		a := &ImapAccount{}
+		var prefix string
+		if envprefix != "" {
+			prefix = envprefix + "_" + strings.ToUpper(name)
+		}
+		if err = Load(path, prefix, a); err != nil {
+			return nil, err
+		}
+		a.Name = name
+
+		for _, prev := range accs {
+			if prev.ImapAddr == a.ImapAddr && prev.ImapLogin == a.ImapLogin {
+				return nil, &Error{File: path, Field: "ImapLogin", Reason: "duplicates account " + prev.Name}
+			}
+		}
+		accs = append(accs, a)
+	}
+	if len(accs) == 0 {
+		return nil, &Error{File: dirpath, Reason: "no accounts"}
+	}
+	return accs, nil
+}
+
+func validAccountName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
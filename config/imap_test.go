@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidAccountName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"mailru", true},
+		{"Yandex_2", true},
+		{"", false},
+		{"mail-ru", false},
+		{"mail.ru", false},
+		{"почта", false},
+	}
+	for _, tt := range tests {
+		if got := validAccountName(tt.name); got != tt.want {
+			t.Errorf("validAccountName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// account's fields are set with env vars, file has only comment
+func setAccount(t *testing.T, dir, name, addr, login string) {
+	t.Helper()
+	if err := os.WriteFile(dir+name+".txt", []byte("# "+name+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "EMAILER_" + strings.ToUpper(name)
+	t.Setenv(prefix+"_IMAP_ADDR", addr)
+	t.Setenv(prefix+"_IMAP_MAILBOXES", "INBOX, Prices")
+	t.Setenv(prefix+"_IMAP_LOGIN", login)
+	t.Setenv(prefix+"_IMAP_PASSWORD", "password")
+}
+
+func TestLoadImapAccounts(t *testing.T) {
+	t.Run("accounts", func(t *testing.T) {
+		dir := t.TempDir() + "/"
+		setAccount(t, dir, "mailru", "imap.mail.ru:993", "prices@mail.ru")
+		setAccount(t, dir, "Yandex_2", "imap.yandex.ru:993", "prices@yandex.ru")
+		os.WriteFile(dir+"notes.md", nil, 0644)
+		os.Mkdir(dir+"old.txt", 0755)
+		accs, err := LoadImapAccounts(dir, "EMAILER")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accs) != 2 || accs[0].Name != "Yandex_2" || accs[1].Name != "mailru" {
+			t.Fatalf("loaded %d accounts %v", len(accs), accs)
+		}
+		if a := accs[1]; a.ImapAddr != "imap.mail.ru:993" || a.ImapLogin != "prices@mail.ru" || len(a.ImapMailboxes) != 2 || a.ImapMailboxes[1] != "Prices" {
+			t.Fatalf("account %+v", a)
+		}
+	})
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, dir string)
+		field   string // of invalid one
+	}{
+		{"invalid name", func(t *testing.T, dir string) {
+			os.WriteFile(dir+"mail-ru.txt", nil, 0644)
+		}, ""},
+		{"duplicated account", func(t *testing.T, dir string) {
+			setAccount(t, dir, "first", "imap.mail.ru:993", "prices@mail.ru")
+			setAccount(t, dir, "second", "imap.mail.ru:993", "prices@mail.ru")
+		}, "ImapLogin"},
+		{"duplicated mailbox", func(t *testing.T, dir string) {
+			setAccount(t, dir, "mailru", "imap.mail.ru:993", "prices@mail.ru")
+			t.Setenv("EMAILER_MAILRU_IMAP_MAILBOXES", "INBOX,INBOX")
+		}, "ImapMailboxes"},
+		{"no accounts", func(t *testing.T, dir string) {}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir() + "/"
+			tt.prepare(t, dir)
+			accs, err := LoadImapAccounts(dir, "EMAILER")
+			cerr, ok := err.(*Error)
+			if !ok || cerr.Field != tt.field {
+				t.Fatalf("LoadImapAccounts() = %v, %v, want invalid %q", accs, err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// supplier's config file in suppliers confs dir
+type Supplier struct {
+	Name     string `conf:"required"`
+	Email    string
+	Filename string `conf:"required"` // name of formatted csv, is unique
+
+	// message is supplier's, if address in one of MailHeaders (from, sender, reply-to; from if empty) is Email,
+	// one of MailSenders or is in one of MailDomains (or their subdomains), and subject matches MailSubjectRegexp, if set
+	MailSenders       []string
+	MailDomains       []string
+	MailHeaders       []string
+	MailSubjectRegexp string
+
+	// attachments are matched with pairs of prefixes and suffixes, one of them may be empty
+	MailFileNamePattern_Prefixes []string
+	MailFileNamePattern_Suffixes []string
+
+	// actions on message after its attachments are saved, both are optional:
+	// flags to add (e.g. \Seen or custom keyword; \Seen if none are set, as message is fetched with peek)
+	// and mailbox to move message into
+	MailAddFlags       []string
+	MailArchiveMailbox string
+
+	// raw csv (unzipped or converted attachment) is matched with prefix and suffix, one of them may be empty
+	RawCsvNamePattern_Prefix string
+	RawCsvNamePattern_Suffix string
+	Charset                  string // of raw csv, utf-8 if empty
+
+	// raw csv format. Brand, partnum, quantity and rest are optional, -1 for missing one
+	// (missing quantity and rest are 0)
+	Delimiter   string
+	Quotes      int
+	FirstRow    int
+	BrandCol    int
+	ArticulCol  int
+	NameCol     []int // joined with space
+	PartnumCol  int
+	PriceCol    int
+	QuantityCol int
+	RestCol     int
+
+	File string // config file, is not decoded
+
+	subjectre *regexp.Regexp
+}
+
+const (
+	HeaderFrom    = "from"
+	HeaderSender  = "sender"
+	HeaderReplyTo = "reply-to"
+)
+
+// default flag of processed messages
+const FlagSeen = `\Seen`
+
+func (s *Supplier) Validate() error {
+	if s.Email == "" && len(s.MailSenders) == 0 && len(s.MailDomains) == 0 {
+		return Invalid("Email", "no Email, MailSenders or MailDomains specified")
+	}
+	if len(s.MailFileNamePattern_Prefixes) == 0 && len(s.MailFileNamePattern_Suffixes) == 0 {
+		return Invalid("MailFileNamePattern_Prefixes", "no mail file patterns specified")
+	}
+	if len(s.MailFileNamePattern_Prefixes) != 0 && len(s.MailFileNamePattern_Suffixes) != 0 && len(s.MailFileNamePattern_Prefixes) != len(s.MailFileNamePattern_Suffixes) {
+		return Invalid("MailFileNamePattern_Suffixes", "length mismatch with MailFileNamePattern_Prefixes")
+	}
+	if strings.TrimSpace(s.RawCsvNamePattern_Prefix) == "" && strings.TrimSpace(s.RawCsvNamePattern_Suffix) == "" {
+		return Invalid("RawCsvNamePattern_Prefix", "no prefix and no suffix specified")
+	}
+	for _, h := range s.MailHeaders {
+		if h = strings.ToLower(h); h != HeaderFrom && h != HeaderSender && h != HeaderReplyTo {
+			return Invalid("MailHeaders", "unknown header "+h+", must be one of "+HeaderFrom+", "+HeaderSender+", "+HeaderReplyTo)
+		}
+	}
+	for _, d := range s.MailDomains {
+		if strings.Trim(d, "@.") == "" {
+			return Invalid("MailDomains", "empty domain")
+		}
+	}
+	if s.MailSubjectRegexp != "" {
+		re, err := regexp.Compile(s.MailSubjectRegexp)
+		if err != nil {
+			return Invalid("MailSubjectRegexp", err.Error())
+		}
+		s.subjectre = re
+	}
+	for _, f := range s.MailAddFlags {
+		if !validFlag(f) {
+			return Invalid("MailAddFlags", "invalid flag "+f)
+		}
+	}
+	if s.Delimiter != "" && utf8.RuneCountInString(s.Delimiter) != 1 {
+		return Invalid("Delimiter", "must be one char")
+	}
+	if len(s.NameCol) == 0 {
+		return Invalid("NameCol", "no columns specified")
+	}
+	for _, col := range s.NameCol {
+		if col < 0 {
+			return Invalid("NameCol", "must not be negative")
+		}
+	}
+	if s.ArticulCol < 0 {
+		return Invalid("ArticulCol", "must not be negative")
+	}
+	if s.PriceCol < 0 {
+		return Invalid("PriceCol", "must not be negative")
+	}
+	for field, col := range map[string]int{"BrandCol": s.BrandCol, "PartnumCol": s.PartnumCol, "QuantityCol": s.QuantityCol, "RestCol": s.RestCol} {
+		if col < -1 {
+			return Invalid(field, "must be -1 for missing column or not negative")
+		}
+	}
+	return nil
+}
+
+// headers to match addresses in, from if none are set
+func (s *Supplier) Headers() []string {
+	if len(s.MailHeaders) == 0 {
+		return []string{HeaderFrom}
+	}
+	return s.MailHeaders
+}
+
+// flags to add to processed message, \Seen if none are set
+func (s *Supplier) AddFlags() []string {
+	if len(s.MailAddFlags) == 0 {
+		return []string{FlagSeen}
+	}
+	return s.MailAddFlags
+}
+
+// returns matched rule, e.g. "email", "sender x@y.ru" or "domain y.ru". addr must be lowercased
+func (s *Supplier) MatchAddress(addr string) (string, bool) {
+	if s.Email != "" && addr == s.Email {
+		return "email", true
+	}
+	for _, a := range s.MailSenders {
+		if addr == a {
+			return "sender " + a, true
+		}
+	}
+	for _, d := range s.MailDomains {
+		if strings.HasSuffix(addr, "@"+d) || strings.HasSuffix(addr, "."+d) {
+			return "domain " + d, true
+		}
+	}
+	return "", false
+}
+
+// true, if there is no subject regexp
+func (s *Supplier) MatchSubject(subject string) bool {
+	switch {
+	case s.subjectre != nil:
+		return s.subjectre.MatchString(subject)
+	case s.MailSubjectRegexp != "":
+		// not validated
+		re, err := regexp.Compile(s.MailSubjectRegexp)
+		return err == nil && re.MatchString(subject)
+	}
+	return true
+}
+
+// system flag (\Seen) or keyword atom
+func validFlag(f string) bool {
+	f = strings.TrimPrefix(f, "\\")
+	return f != "" && !strings.ContainsAny(f, " ()[]{}%*\"\\")
+}
+
+// sorted by raw csv patterns lengths, longest first
+type Suppliers []*Supplier
+
+func (sl Suppliers) Len() int {
+	return len(sl)
+}
+func (sl Suppliers) Less(i, j int) bool {
+	return len(sl[i].RawCsvNamePattern_Prefix)+len(sl[i].RawCsvNamePattern_Suffix) > len(sl[j].RawCsvNamePattern_Prefix)+len(sl[j].RawCsvNamePattern_Suffix)
+}
+func (sl Suppliers) Swap(i, j int) {
+	sl[i], sl[j] = sl[j], sl[i]
+}
+
+// loads all .txt files from dir, patterns and email are lowercased, mail patterns are padded to equal lengths.
+// Suppliers are sorted by raw csv patterns lengths, longest first
+func LoadSuppliers(dirpath string) (Suppliers, error) {
+	files, err := os.ReadDir(dirpath)
+	if err != nil {
+		return nil, err
+	}
+	sups := make(Suppliers, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".txt") {
+			continue
+		}
+		path := dirpath + f.Name()
+		s := &Supplier{}
+		if err = Load(path, "", s); err != nil {
+			return nil, err
+		}
+		s.File = path
+		s.Email = strings.ToLower(s.Email)
+		s.MailSenders = lowered(s.MailSenders, 0)
+		s.MailDomains = lowered(s.MailDomains, 0)
+		for i := range s.MailDomains {
+			s.MailDomains[i] = strings.Trim(s.MailDomains[i], "@.")
+		}
+		s.MailHeaders = lowered(s.MailHeaders, 0)
+		s.RawCsvNamePattern_Prefix = strings.ToLower(s.RawCsvNamePattern_Prefix)
+		s.RawCsvNamePattern_Suffix = strings.ToLower(s.RawCsvNamePattern_Suffix)
+		s.MailFileNamePattern_Prefixes = lowered(s.MailFileNamePattern_Prefixes, len(s.MailFileNamePattern_Suffixes))
+		s.MailFileNamePattern_Suffixes = lowered(s.MailFileNamePattern_Suffixes, len(s.MailFileNamePattern_Prefixes))
+
+		for _, prev := range sups {
+			switch {
+			case prev.Name == s.Name:
+				return nil, &Error{File: path, Field: "Name", Reason: "duplicates " + prev.File}
+			case prev.Filename == s.Filename:
+				return nil, &Error{File: path, Field: "Filename", Reason: "duplicates " + prev.File}
+			case prev.RawCsvNamePattern_Prefix == s.RawCsvNamePattern_Prefix && prev.RawCsvNamePattern_Suffix == s.RawCsvNamePattern_Suffix:
+				return nil, &Error{File: path, Field: "RawCsvNamePattern_Prefix", Reason: "prefix and suffix duplicate " + prev.File}
+			}
+		}
+		sups = append(sups, s)
+	}
+	sort.Sort(sups)
+	return sups, nil
+}
+
+// empty slice is padded to length n
+func lowered(ss []string, n int) []string {
+	if len(ss) == 0 {
+		return make([]string, n)
+	}
+	res := make([]string, len(ss))
+	for i := range ss {
+		res[i] = strings.ToLower(ss[i])
+	}
+	return res
+}
+
+// common csv format, that formatted csvs are written in
+type CsvFormat struct {
+	Delimeter   string `conf:"required"`
+	Quotes      int
+	FirstRow    int
+	BrandCol    int
+	ArticulCol  int
+	NameCol     int
+	PartnumCol  int
+	PriceCol    int
+	QuantityCol int
+	RestCol     int
+}
+
+func (f *CsvFormat) Validate() error {
+	if utf8.RuneCountInString(f.Delimeter) != 1 {
+		return Invalid("Delimeter", "must be one char")
+	}
+	return nil
+}
+
+func LoadCsvFormat(path string) (*CsvFormat, error) {
+	f := &CsvFormat{}
+	if err := Load(path, "", f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
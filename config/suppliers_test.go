@@ -0,0 +1,158 @@
+package config
+
+import "testing"
+
+func TestMatchAddress(t *testing.T) {
+	sup := &Supplier{Email: "prices@sup.ru", MailSenders: []string{"manager@gmail.com"}, MailDomains: []string{"sup-group.ru"}}
+	tests := []struct {
+		addr string
+		rule string
+		ok   bool
+	}{
+		{"prices@sup.ru", "email", true},
+		{"manager@gmail.com", "sender manager@gmail.com", true},
+		{"anyone@sup-group.ru", "domain sup-group.ru", true},
+		{"robot@mail.sup-group.ru", "domain sup-group.ru", true},
+		{"anyone@notsup-group.ru", "", false},
+		{"sup-group.ru@gmail.com", "", false},
+		{"other@sup.ru", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		rule, ok := sup.MatchAddress(tt.addr)
+		if rule != tt.rule || ok != tt.ok {
+			t.Errorf("MatchAddress(%q) = %q, %v, want %q, %v", tt.addr, rule, ok, tt.rule, tt.ok)
+		}
+	}
+	noemail := &Supplier{MailDomains: []string{"sup.ru"}}
+	if rule, ok := noemail.MatchAddress(""); ok {
+		t.Errorf("empty address is matched by %q of supplier without email", rule)
+	}
+}
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		name     string
+		re       string
+		validate bool
+		subject  string
+		want     bool
+	}{
+		{"no regexp", "", true, "anything", true},
+		{"matched", "(?i)прайс", true, "ПРАЙС-лист на 01.05", true},
+		{"not matched", "(?i)прайс", true, "счет на оплату", false},
+		{"not validated", "^Price", false, "Price list", true},
+		{"not validated, bad regexp", "(", false, "(", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sup := validSupplier()
+			sup.MailSubjectRegexp = tt.re
+			if tt.validate {
+				if err := sup.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := sup.MatchSubject(tt.subject); got != tt.want {
+				t.Fatalf("MatchSubject(%q) = %v, want %v", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	sup := &Supplier{}
+	if h := sup.Headers(); len(h) != 1 || h[0] != HeaderFrom {
+		t.Fatalf("default Headers() = %v, want [%s]", h, HeaderFrom)
+	}
+	sup.MailHeaders = []string{HeaderSender, HeaderReplyTo}
+	if h := sup.Headers(); len(h) != 2 || h[0] != HeaderSender || h[1] != HeaderReplyTo {
+		t.Fatalf("Headers() = %v, want %v", h, sup.MailHeaders)
+	}
+}
+
+func TestAddFlags(t *testing.T) {
+	sup := &Supplier{}
+	if f := sup.AddFlags(); len(f) != 1 || f[0] != FlagSeen {
+		t.Fatalf("default AddFlags() = %v, want [%s]", f, FlagSeen)
+	}
+	sup.MailAddFlags = []string{"$Processed"}
+	if f := sup.AddFlags(); len(f) != 1 || f[0] != "$Processed" {
+		t.Fatalf("AddFlags() = %v, want %v", f, sup.MailAddFlags)
+	}
+}
+
+func validSupplier() *Supplier {
+	return &Supplier{Name: "sup", Email: "prices@sup.ru", Filename: "sup.csv", MailFileNamePattern_Prefixes: []string{"price"}, RawCsvNamePattern_Prefix: "price",
+		BrandCol: 0, ArticulCol: 1, NameCol: []int{2}, PartnumCol: -1, PriceCol: 3, QuantityCol: -1, RestCol: 4}
+}
+
+func TestValidateMatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(s *Supplier)
+		field string // of invalid one, empty if valid
+	}{
+		{"valid", func(s *Supplier) {}, ""},
+		{"all headers", func(s *Supplier) { s.MailHeaders = []string{"From", "sender", "Reply-To"} }, ""},
+		{"unknown header", func(s *Supplier) { s.MailHeaders = []string{"to"} }, "MailHeaders"},
+		{"empty domain", func(s *Supplier) { s.MailDomains = []string{"sup.ru", "@."} }, "MailDomains"},
+		{"bad subject regexp", func(s *Supplier) { s.MailSubjectRegexp = "[" }, "MailSubjectRegexp"},
+		{"domain without email", func(s *Supplier) { s.Email, s.MailDomains = "", []string{"sup.ru"} }, ""},
+		{"sender without email", func(s *Supplier) { s.Email, s.MailSenders = "", []string{"manager@gmail.com"} }, ""},
+		{"no addresses", func(s *Supplier) { s.Email = "" }, "Email"},
+		{"flags", func(s *Supplier) { s.MailAddFlags = []string{`\Seen`, "$Processed"} }, ""},
+		{"bad flag", func(s *Supplier) { s.MailAddFlags = []string{"two words"} }, "MailAddFlags"},
+		{"empty flag", func(s *Supplier) { s.MailAddFlags = []string{`\`} }, "MailAddFlags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sup := validSupplier()
+			tt.set(sup)
+			err := sup.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			cerr, ok := err.(*Error)
+			if !ok || cerr.Field != tt.field {
+				t.Fatalf("Validate() = %v, want invalid %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(s *Supplier)
+		field string // of invalid one, empty if valid
+	}{
+		{"missing optional columns", func(s *Supplier) { s.BrandCol, s.PartnumCol, s.QuantityCol, s.RestCol = -1, -1, -1, -1 }, ""},
+		{"several name columns", func(s *Supplier) { s.NameCol = []int{2, 5} }, ""},
+		{"no name columns", func(s *Supplier) { s.NameCol = nil }, "NameCol"},
+		{"negative name column", func(s *Supplier) { s.NameCol = []int{2, -1} }, "NameCol"},
+		{"missing articul", func(s *Supplier) { s.ArticulCol = -1 }, "ArticulCol"},
+		{"missing price", func(s *Supplier) { s.PriceCol = -1 }, "PriceCol"},
+		{"rest below -1", func(s *Supplier) { s.RestCol = -2 }, "RestCol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sup := validSupplier()
+			tt.set(sup)
+			err := sup.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			cerr, ok := err.(*Error)
+			if !ok || cerr.Field != tt.field {
+				t.Fatalf("Validate() = %v, want invalid %s", err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package format
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+	"github.com/okonma-violet/spec/quarantine"
+	"github.com/okonma-violet/spec/watcher"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// format stage: converts suppliers' raw csvs into csvs of common format
+
+const stagename = "csvformatter"
+
+var lockopts = &locker.Options{Timeout: time.Second * 15, MinBackoff: time.Millisecond * 500, MaxBackoff: time.Second * 5}
+
+type Config struct {
+	RawCsvPath         string
+	CsvPath            string
+	SuppliersCsvFormat *config.CsvFormat
+	RemoveProcessed    bool
+	Watcher            *watcher.Watcher   // RawCsvPath's watcher, nil in timer mode
+	Manifest           *manifest.Manifest // nil if provenance is not tracked
+	QuarantinePath     string             // failed files are moved into its stage's dir, if set
+}
+
+// locks RawCsvPath and CsvPath and formats all raw csvs. Returns number of formatted files
+func (c *Config) Format(ctx context.Context, l logger.Logger, sups config.Suppliers) int {
+	defer metrics.JobDuration.Since(time.Now(), stagename)
+	l.Debug("Format", "started")
+	lockstart := time.Now()
+	lease, err := locker.LockDirsLease(ctx, lockopts, c.RawCsvPath, c.CsvPath)
+	metrics.LockWait.Since(lockstart, stagename)
+	if err != nil {
+		if errors.Is(err, locker.ErrLocked) {
+			l.Error("LockDir", errors.New("rawcsv or csv dir locked, timeout reached"))
+		} else {
+			l.Error("LockDir", err)
+		}
+		return 0
+	}
+	defer lease.Unlock()
+
+	files, err := os.ReadDir(c.RawCsvPath)
+	if err != nil {
+		l.Error("Format/ReadDir", err)
+		return 0
+	}
+	if len(files) == 1 && files[0].Name() == locker.LockfileName {
+		l.Debug("ReadDir", "no files")
+		return 0
+	}
+	var processed int
+loop:
+	for _, f := range files {
+		select {
+		case <-lease.Lost():
+			l.Error("Lease", errors.New("lock lost, stopping: "+lease.Check().Error()))
+			return processed
+		default:
+		}
+		fname_lowered := strings.ToLower(f.Name())
+		if f.IsDir() || !strings.HasSuffix(fname_lowered, ".csv") {
+			if strings.HasPrefix(f.Name(), ".") { // lockfiles and temp files
+				continue
+			}
+			l.Warning("Format/ReadDir", "noncsv file founded "+f.Name())
+			continue
+		}
+		if !c.Watcher.Ready(f) {
+			l.Debug("Format", "file is not ready yet, skipped: "+f.Name())
+			continue
+		}
+		for i := 0; i < len(sups); i++ {
+			if strings.HasPrefix(fname_lowered, sups[i].RawCsvNamePattern_Prefix) {
+				if sups[i].RawCsvNamePattern_Suffix != "" && !strings.HasSuffix(fname_lowered, sups[i].RawCsvNamePattern_Suffix) {
+					continue
+				}
+				if err = c.formatCSV(f.Name(), sups[i]); err != nil {
+					l.Error("Format/formatCSV", errors.New("file: "+f.Name()+", err: "+err.Error()))
+					c.fail(ctx, l, c.RawCsvPath, f.Name(), "format: "+err.Error())
+					continue
+				}
+				l.Debug("Format", "csv formatted: "+f.Name()+" to: "+sups[i].Filename)
+				if err = c.Manifest.Transit(ctx, f.Name(), manifest.Formatted, stagename, sups[i].Filename); err != nil {
+					l.Error("Manifest.Transit", err)
+				}
+				metrics.FilesProcessed.Inc(stagename, sups[i].Name)
+
+				processed++
+				if c.RemoveProcessed {
+					if err = os.Remove(c.RawCsvPath + f.Name()); err != nil {
+						l.Error("Format/Remove", err)
+					}
+					l.Debug("Format", "removed "+f.Name())
+				}
+				continue loop
+
+			}
+		}
+		l.Error("Format", errors.New("unknown rawcsv filename: "+f.Name()))
+		c.fail(ctx, l, c.RawCsvPath, f.Name(), "unknown rawcsv filename")
+	}
+	l.Debug("Format", "done")
+	return processed
+}
+
+// fails file's manifest entry and moves file from dir into stage's quarantine dir
+func (c *Config) fail(ctx context.Context, l logger.Logger, dir, file string, reason string) {
+	if err := quarantine.Put(ctx, c.Manifest, c.QuarantinePath, dir, file, stagename, reason); err != nil {
+		l.Error("Quarantine", err)
+		return
+	}
+	if c.QuarantinePath != "" {
+		l.Warning("Quarantine", "file moved into quarantine: "+file+", reason: "+reason)
+	}
+}
+
+// нет проверки соответствия форматов длинам слайсов
+// does NOT lock dir. Output is written into temp dot file and renamed on success,
+// so half-written csv is never left in CsvPath
+func (c *Config) formatCSV(filename string, sup *config.Supplier) (err error) {
+	if sup.Filename == "" {
+		return errors.New("nil or empty given format")
+	}
+	if filename == "" {
+		return errors.New("empty given filename")
+	}
+	rawfile, err := os.Open(c.RawCsvPath + filename)
+	if err != nil {
+		return err
+	}
+	defer rawfile.Close()
+	tmppath := c.CsvPath + "." + sup.Filename + ".tmp"
+	cleanfile, err := os.Create(tmppath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cleanfile.Close()
+		if err != nil {
+			os.Remove(tmppath)
+		}
+	}()
+
+	var def_r io.Reader = rawfile
+	if sup.Charset != "" {
+		if sup.Charset == "1251" {
+			def_r = charmap.Windows1251.NewDecoder().Reader(def_r)
+		} else {
+			return errors.New("unsupportable charset: " + sup.Charset)
+		}
+	}
+	r := csv.NewReader(def_r)
+	r.Comma = []rune(sup.Delimiter)[0]
+	r.LazyQuotes = sup.Quotes == 1
+	r.ReuseRecord = true
+
+	w := csv.NewWriter(cleanfile)
+	w.Comma = []rune(c.SuppliersCsvFormat.Delimeter)[0]
+	buf := make([]string, 8)
+	buf[c.SuppliersCsvFormat.BrandCol], buf[c.SuppliersCsvFormat.ArticulCol], buf[c.SuppliersCsvFormat.NameCol], buf[c.SuppliersCsvFormat.PartnumCol], buf[c.SuppliersCsvFormat.PriceCol], buf[c.SuppliersCsvFormat.QuantityCol], buf[c.SuppliersCsvFormat.RestCol] = "BRAND", "ARTICUL", "NAME", "PARTNUM", "PRICE", "QUANTITY", "REST"
+	err = w.Write(buf)
+	if err != nil {
+		return err
+	}
+	if sup.FirstRow > 0 {
+		for i := 0; i < sup.FirstRow; i++ {
+			_, err = r.Read()
+			if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+				return err
+			}
+		}
+	}
+	if !requiredColumns(sup) {
+		return errors.New("supplier's name, articul or price column is not set")
+	}
+	maxcol := maxColumn(sup)
+	for {
+		readed, err := r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, csv.ErrFieldCount) {
+				metrics.RowsRead.Inc(stagename, sup.Name)
+				metrics.RowsRejected.Inc(stagename, sup.Name, "field_count")
+				continue
+			}
+			return err
+		}
+		metrics.RowsRead.Inc(stagename, sup.Name)
+		if len(readed) <= maxcol {
+			metrics.RowsRejected.Inc(stagename, sup.Name, "field_count")
+			continue
+		}
+		name := strings.TrimSpace(readed[sup.NameCol[0]])
+		if len(sup.NameCol) > 1 {
+			for i := 1; i < len(sup.NameCol); i++ {
+				name += " " + strings.TrimSpace(readed[sup.NameCol[i]])
+			}
+		}
+		buf[c.SuppliersCsvFormat.BrandCol],
+			buf[c.SuppliersCsvFormat.ArticulCol],
+			buf[c.SuppliersCsvFormat.NameCol],
+			buf[c.SuppliersCsvFormat.PartnumCol],
+			buf[c.SuppliersCsvFormat.PriceCol],
+			buf[c.SuppliersCsvFormat.QuantityCol],
+			buf[c.SuppliersCsvFormat.RestCol] = strings.TrimSpace(column(readed, sup.BrandCol, "")), normart(readed[sup.ArticulCol]), normnaim(name), column(readed, sup.PartnumCol, ""), normprice(readed[sup.PriceCol]), column(readed, sup.QuantityCol, "0"), normnum(column(readed, sup.RestCol, "0"))
+		err = w.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+	if err = cleanfile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, c.CsvPath+sup.Filename)
+}
+
+// supplier may be not validated
+func requiredColumns(sup *config.Supplier) bool {
+	if len(sup.NameCol) == 0 || sup.ArticulCol < 0 || sup.PriceCol < 0 {
+		return false
+	}
+	for _, col := range sup.NameCol {
+		if col < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// optional column, def if it is missing (-1)
+func column(row []string, col int, def string) string {
+	if col < 0 {
+		return def
+	}
+	return row[col]
+}
+
+// the biggest index of supplier's columns, rows must be longer
+func maxColumn(sup *config.Supplier) int {
+	max := sup.BrandCol
+	for _, col := range append([]int{sup.ArticulCol, sup.PartnumCol, sup.PriceCol, sup.QuantityCol, sup.RestCol}, sup.NameCol...) {
+		if col > max {
+			max = col
+		}
+	}
+	return max
+}
+
+var pricerx = regexp.MustCompile("[^а-яa-z0-9.,]")
+var naimrx = regexp.MustCompile(`\s{2,}`)
+var artrx = regexp.MustCompile("[^а-яa-z0-9]")
+var numberrx = regexp.MustCompile(`[^0-9]`)
+
+func normprice(s string) string {
+	return pricerx.ReplaceAllString(s, "")
+}
+func normnum(s string) string {
+	return numberrx.ReplaceAllString(strings.ToLower(s), "")
+}
+func normnaim(s string) string {
+	return naimrx.ReplaceAllString(strings.TrimSpace(s), " ")
+}
+
+func normart(s string) string {
+	return artrx.ReplaceAllString(strings.ToLower(s), "")
+}
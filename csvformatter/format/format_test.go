@@ -0,0 +1,81 @@
+package format
+
+import (
+	"encoding/csv"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/okonma-violet/spec/config"
+)
+
+func TestFormatCSV(t *testing.T) {
+	csvformat := &config.CsvFormat{Delimeter: ";", BrandCol: 0, ArticulCol: 1, NameCol: 2, PartnumCol: 3, PriceCol: 4, QuantityCol: 5, RestCol: 6}
+	optional := func() *config.Supplier {
+		return &config.Supplier{Name: "sup", Filename: "sup.csv", Delimiter: ",", FirstRow: 1,
+			BrandCol: -1, ArticulCol: 0, NameCol: []int{1, 2}, PartnumCol: -1, PriceCol: 3, QuantityCol: -1, RestCol: -1}
+	}
+	tests := []struct {
+		name    string
+		sup     func() *config.Supplier
+		raw     string
+		want    [][]string // without header, formatted rows have 8 fields
+		wanterr bool
+	}{
+		{"missing optional columns", optional, "art,name,name2,price\nAB-1,Filter, oil,100.5\n",
+			[][]string{{"", "ab1", "Filter oil", "", "100.5", "0", "0", ""}}, false},
+		{"all columns", func() *config.Supplier {
+			sup := optional()
+			sup.BrandCol, sup.PartnumCol, sup.QuantityCol, sup.RestCol = 4, 5, 6, 7
+			return sup
+		}, "art,name,name2,price,brand,partnum,quantity,rest\nAB-1,Filter,oil,100,Bosch,P1,2,>10\n",
+			[][]string{{"Bosch", "ab1", "Filter oil", "P1", "100", "2", "10", ""}}, false},
+		{"rows with other field count", optional, "art,name,name2,price\nAB-1,Filter\nAB-2,Filter,oil,1,extra\nAB-3,Filter,oil,3\n",
+			[][]string{{"", "ab3", "Filter oil", "", "3", "0", "0", ""}}, false},
+		{"rows shorter than columns", optional, "art,name,price\nAB-1,Filter,1\n", nil, false},
+		{"required column is not set", func() *config.Supplier {
+			sup := optional()
+			sup.PriceCol = -1
+			return sup
+		}, "art,name,name2,price\nAB-1,Filter,oil,1\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{RawCsvPath: t.TempDir() + "/", CsvPath: t.TempDir() + "/", SuppliersCsvFormat: csvformat}
+			if err := os.WriteFile(c.RawCsvPath+"raw.csv", []byte(tt.raw), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sup := tt.sup()
+			err := c.formatCSV("raw.csv", sup)
+			if tt.wanterr {
+				if err == nil {
+					t.Fatal("formatCSV() err = nil")
+				}
+				if files, _ := os.ReadDir(c.CsvPath); len(files) != 0 {
+					t.Fatalf("%d files are left in CsvPath", len(files))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(c.CsvPath + sup.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r := csv.NewReader(f)
+			r.Comma = ';'
+			rows, err := r.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) == 0 || rows[0][csvformat.ArticulCol] != "ARTICUL" {
+				t.Fatalf("no header in %v", rows)
+			}
+			if got := rows[1:]; !reflect.DeepEqual(got, tt.want) && (len(got) != 0 || len(tt.want) != 0) {
+				t.Fatalf("formatted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
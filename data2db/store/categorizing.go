@@ -1,10 +1,12 @@
-package main
+package store
 
 import (
-	"fmt"
+	"context"
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/okonma-violet/spec/logs/logger"
 )
 
 type comparativeArray []*keyPhrase
@@ -35,26 +37,26 @@ func (ca comparativeArray) Swap(i, j int) {
 	ca[j] = b
 }
 
-func (r *repo) Categorize() error {
+// sets categories of uncategorized articuls by keyphrases found in their products' names.
+// logger is taken from ctx
+func (r *Repo) Categorize(ctx context.Context) error {
+	l := logger.FromContext(ctx)
 	var keysarr comparativeArray
-	keysarr, err := r.GetCategoriesKeyphrases()
+	keysarr, err := r.GetCategoriesKeyphrases(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	sort.Sort(keysarr)
 
-	ars, err := r.GetUncategorizedArticulesWithBrandids()
+	ars, err := r.GetUncategorizedArticulesWithBrandids(ctx)
 	if err != nil {
 		return err
 	}
-
-	for i, k := range ars {
-		fmt.Println(i, k)
-	}
+	l.Debug("Categorize", "uncategorized articuls", logger.Int("articuls", len(ars)))
 
 	var cated int
 	for i := 0; i < len(ars); i++ {
-		names, err := r.GetProductsNamesByArtAndBrand(ars[i].articul, ars[i].brandid)
+		names, err := r.GetProductsNamesByArtAndBrand(ctx, ars[i].articul, ars[i].brandid)
 		if err != nil {
 			return err
 		}
@@ -66,13 +68,13 @@ func (r *repo) Categorize() error {
 			}
 		}
 		if catid != 0 {
-			if err = r.UpdateArticulCategory(ars[i].articul, ars[i].brandid, catid); err != nil {
+			if err = r.UpdateArticulCategory(ctx, ars[i].articul, ars[i].brandid, catid); err != nil {
 				return err
 			}
 			cated++
 		}
 	}
-	fmt.Println("Categorized", cated)
+	l.Info("Categorize", "articuls categorized", logger.Int("categorized", cated), logger.Int("articuls", len(ars)))
 	return nil
 }
 
@@ -1,4 +1,4 @@
-package main
+package store
 
 import (
 	"context"
@@ -12,10 +12,10 @@ import (
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/pgconn"
-	"github.com/okonma-violet/confdecoder"
+	"github.com/okonma-violet/spec/config"
 )
 
-func (r *repo) loadBrandsFromFile(filepath string) error {
+func (r *Repo) LoadBrandsFromFile(ctx context.Context, filepath string) error {
 	file, err := os.Open(filepath)
 	if err != nil {
 		return err
@@ -25,7 +25,7 @@ func (r *repo) loadBrandsFromFile(filepath string) error {
 	rdr := csv.NewReader(file)
 	rdr.Comma = []rune(",")[0]
 	rdr.LazyQuotes = true
-	if _, err := r.db.Exec(context.Background(), "INSERT INTO brands(name,norm) values($1,$2)", "NO_BRAND", []string{"NO_BRAND"}); err != nil {
+	if _, err := r.db.Exec(ctx, "INSERT INTO brands(name,norm) values($1,$2)", "NO_BRAND", []string{"NO_BRAND"}); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code != pgerrcode.UniqueViolation {
 			return err
@@ -64,7 +64,7 @@ func (r *repo) loadBrandsFromFile(filepath string) error {
 		if len(norms) == 0 {
 			norms = append(norms, normstring(name))
 		}
-		if _, err = r.CreateBrand(name, norms); err != nil {
+		if _, err = r.CreateBrand(ctx, name, norms); err != nil {
 			if errors.Is(err, ErrDuplicate) {
 				dups++
 				continue
@@ -78,32 +78,16 @@ func (r *repo) loadBrandsFromFile(filepath string) error {
 	return nil
 }
 
-func (r *repo) loadSuppliersConfigsFromDir(path string) error {
-	files, err := os.ReadDir(path)
+// creates suppliers from configs in dir, existing ones are skipped
+func (r *Repo) LoadSuppliersConfigsFromDir(ctx context.Context, path string) error {
+	sups, err := config.LoadSuppliers(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	var dups int
 	var sucs int
-	for _, f := range files {
-		if f.IsDir() || !strings.HasSuffix(f.Name(), ".txt") {
-			fmt.Println("AddSuppliers", "nontxt founded:", f.Name())
-			continue
-		}
-
-		sfrm := supplier{}
-		err := confdecoder.DecodeFile(path+f.Name(), &sfrm)
-		if err != nil {
-			panic("read supplier's config file err: " + err.Error())
-		}
-		if sfrm.Name == "" || sfrm.Filename == "" {
-			fmt.Println("AddSuppliers", "no data in supplier's config file:", f.Name())
-			continue
-		}
-		if _, err = r.CreateSupplier(
-			sfrm.Name,
-			sfrm.Email,
-			sfrm.Filename); err != nil {
+	for _, s := range sups {
+		if _, err = r.CreateSupplier(ctx, s.Name, s.Email, s.Filename); err != nil {
 			if errors.Is(err, ErrDuplicate) {
 				dups++
 				continue
@@ -116,7 +100,7 @@ func (r *repo) loadSuppliersConfigsFromDir(path string) error {
 	return nil
 }
 
-func (rep *repo) loadCategoriesWithKeyphrasesFromFile(filepath string) error {
+func (rep *Repo) LoadCategoriesWithKeyphrasesFromFile(ctx context.Context, filepath string) error {
 	file, err := os.Open(filepath)
 	if err != nil {
 		return (err)
@@ -141,10 +125,10 @@ func (rep *repo) loadCategoriesWithKeyphrasesFromFile(filepath string) error {
 		if len(norm) == 0 {
 			return errors.New("gets empty normname of cat on line " + strconv.Itoa(i+1))
 		}
-		catid, err := rep.AddCategory(row[1], norm)
+		catid, err := rep.AddCategory(ctx, row[1], norm)
 		if err != nil {
 			if errors.Is(err, ErrDuplicate) {
-				catid, err = rep.GetCategoryIdByNorm(normstring(row[1]))
+				catid, err = rep.GetCategoryIdByNorm(ctx, normstring(row[1]))
 				if err != nil {
 					return err
 				}
@@ -158,7 +142,7 @@ func (rep *repo) loadCategoriesWithKeyphrasesFromFile(filepath string) error {
 			if len(kp) == 0 {
 				continue
 			}
-			if err = rep.AddCategoryKeyphrase(catid, kp); err != nil && !errors.Is(err, ErrDuplicate) {
+			if err = rep.AddCategoryKeyphrase(ctx, catid, kp); err != nil && !errors.Is(err, ErrDuplicate) {
 				return err
 			}
 		}
@@ -1,4 +1,4 @@
-package main
+package store
 
 import (
 	"context"
@@ -18,16 +18,40 @@ import (
 var ErrDuplicate = errors.New("duplicates")
 var ErrNotExists = errors.New("not found")
 
-type repo struct {
-	db *pgx.Conn
+// queries are made with caller's ctx, and traced into its logger
+type Repo struct {
+	db    *pgx.Conn
+	trace bool
 }
 
-func (r *repo) OpenDBRepository(connectionString string) (err error) {
-	r.db, err = pgx.Connect(context.Background(), connectionString)
+// if trace, queries are logged into logger of query's ctx
+func NewRepo(trace bool) *Repo {
+	return &Repo{trace: trace}
+}
+
+func (r *Repo) Ping(ctx context.Context) error {
+	return r.db.Ping(ctx)
+}
+
+func (r *Repo) Close() error {
+	return r.db.Close(context.Background())
+}
+
+func (r *Repo) OpenDBRepository(ctx context.Context, connectionString string) (err error) {
+	if !r.trace {
+		r.db, err = pgx.Connect(ctx, connectionString)
+		return err
+	}
+	cfg, err := pgx.ParseConfig(connectionString)
+	if err != nil {
+		return err
+	}
+	cfg.Tracer = &queryTracer{}
+	r.db, err = pgx.ConnectConfig(ctx, cfg)
 	return err
 }
 
-func (rep *repo) Migrate(droptables bool) error {
+func (rep *Repo) Migrate(ctx context.Context, droptables bool) error {
 	var query string
 	if droptables {
 		query += `
@@ -66,7 +90,8 @@ func (rep *repo) Migrate(droptables bool) error {
 	query += `
 	CREATE TABLE "uploads" (
 		"id" SERIAL NOT NULL PRIMARY KEY,
-		"time"  TIMESTAMP NOT NULL DEFAULT current_timestamp
+		"time"  TIMESTAMP NOT NULL DEFAULT current_timestamp,
+		"manifestid" TEXT
 	);
     `
 	query += `
@@ -133,21 +158,30 @@ func (rep *repo) Migrate(droptables bool) error {
 	query += `
 	CREATE UNIQUE INDEX products_unique_id ON products (supplierid,name,brandid,articul);
 	`
-	_, err := rep.db.Exec(context.Background(), query)
+	_, err := rep.db.Exec(ctx, query)
+	return err
+}
+
+// idempotent upgrades of tables, created by earlier versions of Migrate. Runs on startup
+func (rep *Repo) Upgrade(ctx context.Context) error {
+	_, err := rep.db.Exec(ctx, `
+	ALTER TABLE IF EXISTS "uploads" ADD COLUMN IF NOT EXISTS "manifestid" TEXT;
+	`)
 	return err
 }
 
-func (r *repo) CreateUpload() (int, error) {
+// empty manifestid is stored as null
+func (r *Repo) CreateUpload(ctx context.Context, manifestid string) (int, error) {
 	id := 0
-	if err := r.db.QueryRow(context.Background(), "INSERT INTO uploads(time) values(now()) RETURNING id").Scan(&id); err != nil {
+	if err := r.db.QueryRow(ctx, "INSERT INTO uploads(time,manifestid) values(now(),NULLIF($1,'')) RETURNING id", manifestid).Scan(&id); err != nil {
 		return 0, err
 	}
 	return id, nil
 }
 
-func (r *repo) AddCategory(name, normname string) (int, error) {
+func (r *Repo) AddCategory(ctx context.Context, name, normname string) (int, error) {
 	id := 0
-	if err := r.db.QueryRow(context.Background(), "INSERT INTO categories(name,norm) values($1,$2) RETURNING id", name, normname).Scan(&id); err != nil {
+	if err := r.db.QueryRow(ctx, "INSERT INTO categories(name,norm) values($1,$2) RETURNING id", name, normname).Scan(&id); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 			return 0, ErrDuplicate
@@ -157,9 +191,9 @@ func (r *repo) AddCategory(name, normname string) (int, error) {
 	return id, nil
 }
 
-func (r *repo) GetCategoryIdByNorm(norm string) (int, error) {
+func (r *Repo) GetCategoryIdByNorm(ctx context.Context, norm string) (int, error) {
 	var id int
-	if err := r.db.QueryRow(context.Background(), "SELECT id FROM categories WHERE norm=$1", norm).Scan(&id); err != nil {
+	if err := r.db.QueryRow(ctx, "SELECT id FROM categories WHERE norm=$1", norm).Scan(&id); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, ErrNotExists
 		}
@@ -168,8 +202,8 @@ func (r *repo) GetCategoryIdByNorm(norm string) (int, error) {
 	return id, nil
 }
 
-func (r *repo) AddCategoryKeyphrase(categoryid int, keyphrase string) error {
-	if _, err := r.db.Exec(context.Background(), "INSERT INTO categories_keyphrases(keyphrase,categoryid) values($1,$2)", keyphrase, categoryid); err != nil {
+func (r *Repo) AddCategoryKeyphrase(ctx context.Context, categoryid int, keyphrase string) error {
+	if _, err := r.db.Exec(ctx, "INSERT INTO categories_keyphrases(keyphrase,categoryid) values($1,$2)", keyphrase, categoryid); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 			return ErrDuplicate
@@ -179,8 +213,8 @@ func (r *repo) AddCategoryKeyphrase(categoryid int, keyphrase string) error {
 	return nil
 }
 
-func (r *repo) GetCategoriesKeyphrases() ([]*keyPhrase, error) {
-	rows, err := r.db.Query(context.Background(), "SELECT keyphrase,categoryid FROM categories_keyphrases")
+func (r *Repo) GetCategoriesKeyphrases(ctx context.Context) ([]*keyPhrase, error) {
+	rows, err := r.db.Query(ctx, "SELECT keyphrase,categoryid FROM categories_keyphrases")
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotExists
@@ -199,15 +233,15 @@ func (r *repo) GetCategoriesKeyphrases() ([]*keyPhrase, error) {
 	return kps, nil
 }
 
-func (r *repo) GetOrCreateProduct(articul string, supplierid, brandid int, name string, partnum string, quantity int) (int, error) {
+func (r *Repo) GetOrCreateProduct(ctx context.Context, articul string, supplierid, brandid int, name string, partnum string, quantity int) (int, error) {
 	hashstr, err := getProductMD5(brandid, articul, name)
 	if err != nil {
 		return 0, err
 	}
 	id := 0
-	if err := r.db.QueryRow(context.Background(), "SELECT id FROM products where hash=$1", hashstr).Scan(&id); err != nil {
+	if err := r.db.QueryRow(ctx, "SELECT id FROM products where hash=$1", hashstr).Scan(&id); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			if err := r.db.QueryRow(context.Background(), `INSERT INTO products(articul,supplierid,brandid,name,partnum,quantity,hash)
+			if err := r.db.QueryRow(ctx, `INSERT INTO products(articul,supplierid,brandid,name,partnum,quantity,hash)
 			values($1,$2,$3,$4,$5,$6,$7)
 			RETURNING id`, articul, supplierid, brandid, name, partnum, quantity, hashstr).Scan(&id); err != nil {
 				return 0, err
@@ -219,16 +253,16 @@ func (r *repo) GetOrCreateProduct(articul string, supplierid, brandid int, name
 	return id, nil
 }
 
-func (r *repo) UpsertActualPrice(productid, uploadid int, price float32, rest int) error {
-	_, err := r.db.Exec(context.Background(), `INSERT INTO prices_actual(productid,uploadid,price,rest)
+func (r *Repo) UpsertActualPrice(ctx context.Context, productid, uploadid int, price float32, rest int) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO prices_actual(productid,uploadid,price,rest)
 	values($1,$2,$3,$4)
 	ON CONFLICT (productid) 
 	DO UPDATE SET price=EXCLUDED.price,rest=EXCLUDED.rest,uploadid=EXCLUDED.uploadid`, productid, uploadid, price, rest)
 	return err
 }
 
-func (r *repo) UpdateOutOfStock(supplierid, uploadid int) (int, error) {
-	ct, err := r.db.Exec(context.Background(), `UPDATE prices_actual
+func (r *Repo) UpdateOutOfStock(ctx context.Context, supplierid, uploadid int) (int, error) {
+	ct, err := r.db.Exec(ctx, `UPDATE prices_actual
 	SET rest=0,uploadid=$2
 	FROM (SELECT id FROM products WHERE supplierid=$1) AS subq
 	WHERE prices_actual.productid=subq.id
@@ -236,15 +270,15 @@ func (r *repo) UpdateOutOfStock(supplierid, uploadid int) (int, error) {
 	return int(ct.RowsAffected()), err
 }
 
-func (r *repo) InsertHistoryPrice(productid, uploadid int, price float32, rest int) error {
-	_, err := r.db.Exec(context.Background(), `INSERT INTO prices_history(productid,uploadid,price,rest)
+func (r *Repo) InsertHistoryPrice(ctx context.Context, productid, uploadid int, price float32, rest int) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO prices_history(productid,uploadid,price,rest)
 	values($1,$2,$3,$4)`, productid, uploadid, price, rest)
 	return err
 }
 
 // adds additional articules if not exists or ones not equal with given
-func (r *repo) UpsertArticul(articul string, brandid int, additional_articuls []string) error {
-	_, err := r.db.Exec(context.Background(), `INSERT INTO articuls(articul,additional_articul,brandid,categoryid)
+func (r *Repo) UpsertArticul(ctx context.Context, articul string, brandid int, additional_articuls []string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO articuls(articul,additional_articul,brandid,categoryid)
 	VALUES($1,$2,$3,null)
 	ON CONFLICT (articul,brandid)
 	DO UPDATE SET additional_articul=EXCLUDED.additional_articul
@@ -254,8 +288,8 @@ func (r *repo) UpsertArticul(articul string, brandid int, additional_articuls []
 }
 
 // appends nonexisting additional_articuls
-func (r *repo) UpsertArticul_NoAdditionalArticulesRewriting(articul string, brandid int, additional_articuls []string) error {
-	_, err := r.db.Exec(context.Background(), `INSERT INTO articuls(articul,additional_articul,brandid,categoryid)
+func (r *Repo) UpsertArticul_NoAdditionalArticulesRewriting(ctx context.Context, articul string, brandid int, additional_articuls []string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO articuls(articul,additional_articul,brandid,categoryid)
 	VALUES($1,$2,$3,null)
 	ON CONFLICT (articul,brandid)
 	DO UPDATE SET additional_articul= (select array_agg(distinct e) from unnest(additional_articul || EXCLUDED.additional_articul) e)
@@ -263,8 +297,8 @@ func (r *repo) UpsertArticul_NoAdditionalArticulesRewriting(articul string, bran
 
 	return err
 }
-func (r *repo) UpdateArticulCategory(articul string, brandid, categoryid int) error {
-	ct, err := r.db.Exec(context.Background(), "UPDATE articuls SET categoryid = $1 WHERE articul = $2 AND brandid = $3", categoryid, articul, brandid)
+func (r *Repo) UpdateArticulCategory(ctx context.Context, articul string, brandid, categoryid int) error {
+	ct, err := r.db.Exec(ctx, "UPDATE articuls SET categoryid = $1 WHERE articul = $2 AND brandid = $3", categoryid, articul, brandid)
 	if err != nil {
 		return err
 	}
@@ -280,8 +314,8 @@ type articulrow struct {
 	categoryid int
 }
 
-func (r *repo) GetUncategorizedArticulesWithBrandids() ([]*articulrow, error) {
-	rows, err := r.db.Query(context.Background(), "SELECT articul,brandid FROM articuls WHERE categoryid is null")
+func (r *Repo) GetUncategorizedArticulesWithBrandids(ctx context.Context) ([]*articulrow, error) {
+	rows, err := r.db.Query(ctx, "SELECT articul,brandid FROM articuls WHERE categoryid is null")
 	if err != nil {
 		return nil, err
 	}
@@ -297,8 +331,8 @@ func (r *repo) GetUncategorizedArticulesWithBrandids() ([]*articulrow, error) {
 	return result, nil
 }
 
-func (r *repo) GetProductsNamesByArtAndBrand(articul string, brandid int) ([]string, error) {
-	rows, err := r.db.Query(context.Background(), "SELECT name FROM products WHERE articul=$1 AND brandid=$2", articul, brandid)
+func (r *Repo) GetProductsNamesByArtAndBrand(ctx context.Context, articul string, brandid int) ([]string, error) {
+	rows, err := r.db.Query(ctx, "SELECT name FROM products WHERE articul=$1 AND brandid=$2", articul, brandid)
 	if err != nil {
 		return nil, err
 	}
@@ -314,9 +348,9 @@ func (r *repo) GetProductsNamesByArtAndBrand(articul string, brandid int) ([]str
 	return result, nil
 }
 
-func (r *repo) CreateBrand(name string, norm []string) (int, error) {
+func (r *Repo) CreateBrand(ctx context.Context, name string, norm []string) (int, error) {
 	var id int
-	if err := r.db.QueryRow(context.Background(), "INSERT INTO brands(name,norm) values($1,$2) RETURNING id", name, norm).Scan(&id); err != nil {
+	if err := r.db.QueryRow(ctx, "INSERT INTO brands(name,norm) values($1,$2) RETURNING id", name, norm).Scan(&id); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 			return 0, ErrDuplicate
@@ -326,10 +360,10 @@ func (r *repo) CreateBrand(name string, norm []string) (int, error) {
 	return id, nil
 }
 
-func (r *repo) GetBrandIdByNorm(norm string) (int, []string, error) {
+func (r *Repo) GetBrandIdByNorm(ctx context.Context, norm string) (int, []string, error) {
 	var id int
 	var norms []string
-	if err := r.db.QueryRow(context.Background(), "SELECT id,norm FROM brands WHERE $1 = ANY (norm)", norm).Scan(&id, &norms); err != nil { //norm@>ARRAY[$1]
+	if err := r.db.QueryRow(ctx, "SELECT id,norm FROM brands WHERE $1 = ANY (norm)", norm).Scan(&id, &norms); err != nil { //norm@>ARRAY[$1]
 		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, nil, ErrNotExists
 		}
@@ -345,9 +379,9 @@ type supplier struct {
 	Filename string
 }
 
-func (r *repo) CreateSupplier(name, email, filename string) (int, error) {
+func (r *Repo) CreateSupplier(ctx context.Context, name, email, filename string) (int, error) {
 	id := 0
-	if err := r.db.QueryRow(context.Background(), "INSERT INTO suppliers(name,email,filename) values($1,$2,$3) RETURNING id", name, strings.ToLower(email), strings.ToLower(filename)).Scan(&id); err != nil {
+	if err := r.db.QueryRow(ctx, "INSERT INTO suppliers(name,email,filename) values($1,$2,$3) RETURNING id", name, strings.ToLower(email), strings.ToLower(filename)).Scan(&id); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 			return 0, ErrDuplicate
@@ -357,9 +391,9 @@ func (r *repo) CreateSupplier(name, email, filename string) (int, error) {
 	return id, nil
 }
 
-func (r *repo) GetSupplierByFilename(filename string) (*supplier, error) {
+func (r *Repo) GetSupplierByFilename(ctx context.Context, filename string) (*supplier, error) {
 	sup := supplier{}
-	if err := r.db.QueryRow(context.Background(), "SELECT id,name,email,filename FROM suppliers WHERE filename=($1)", filename).Scan(
+	if err := r.db.QueryRow(ctx, "SELECT id,name,email,filename FROM suppliers WHERE filename=($1)", filename).Scan(
 		&sup.id, &sup.Name, &sup.Email, &sup.Filename); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotExists
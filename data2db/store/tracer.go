@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+// logs repo's queries into query ctx's logger: done ones are debugs, failed ones are warnings (repo decides, are they errors or not)
+type queryTracer struct{}
+
+type querystartkey struct{}
+
+type querystart struct {
+	sql  string
+	time time.Time
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, querystartkey{}, querystart{sql: data.SQL, time: time.Now()})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	qs, _ := ctx.Value(querystartkey{}).(querystart)
+	l := logger.FromContext(ctx)
+	if data.Err != nil {
+		l.Warning("Query", data.Err.Error(), logger.String("sql", qs.sql), logger.Duration("duration", time.Since(qs.time)))
+		return
+	}
+	l.Debug("Query", data.CommandTag.String(), logger.String("sql", qs.sql), logger.Duration("duration", time.Since(qs.time)))
+}
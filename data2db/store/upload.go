@@ -0,0 +1,293 @@
+package store
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+	"github.com/okonma-violet/spec/quarantine"
+	"github.com/okonma-violet/spec/watcher"
+)
+
+// upload stage: uploads products from csvs of common format into db
+
+const stagename = "data2db"
+
+var lockopts = &locker.Options{Timeout: time.Second * 15, MinBackoff: time.Millisecond * 500, MaxBackoff: time.Second * 5}
+
+type Config struct {
+	ProductsCsvPath             string
+	AlternativeArticulsFilePath string
+	SuppliersCsvFormat          *config.CsvFormat
+	RemoveProcessed             bool
+	Watcher                     *watcher.Watcher   // ProductsCsvPath's watcher, nil in timer mode
+	Manifest                    *manifest.Manifest // nil if provenance is not tracked
+	QuarantinePath              string             // failed files are moved into its stage's dir, if set
+}
+
+// locks ProductsCsvPath and uploads all csvs, every file gets its own upload with file's manifest id.
+// Returns number of uploaded files.
+// logger is taken from ctx, logs of every file are tagged with file, supplier and upload id
+func (conf *Config) Upload(ctx context.Context, rep *Repo) int {
+	defer metrics.JobDuration.Since(time.Now(), stagename)
+	var processed int
+	l := logger.FromContext(ctx)
+	lockstart := time.Now()
+	lease, err := locker.LockDirLease(ctx, conf.ProductsCsvPath, lockopts)
+	metrics.LockWait.Since(lockstart, stagename)
+	if err != nil {
+		if errors.Is(err, locker.ErrLocked) {
+			l.Error("LockDir", errors.New("csv dir locked, timeout reached"))
+		} else {
+			l.Error("LockDir", err)
+		}
+		return processed
+	}
+	defer lease.Unlock()
+
+	files, err := os.ReadDir(conf.ProductsCsvPath)
+	if err != nil {
+		l.Error("ReadDir", err)
+		return processed
+	}
+	if len(files) == 1 && files[0].Name() == locker.LockfileName {
+		l.Debug("ReadDir", "no files")
+		return processed
+	}
+
+	// CREATING ALTERNATIVE ARTICULES
+	altarts, err := loadAlternativeArticulesFromFile(conf.AlternativeArticulsFilePath)
+	if err != nil {
+		l.Error("loadAlternativeArticulesFromFile", err)
+		return processed
+	}
+
+	// FILES LOOP
+fileloop:
+	for _, f := range files {
+		select {
+		case <-lease.Lost():
+			l.Error("Lease", errors.New("lock lost, stopping: "+lease.Check().Error()))
+			return processed
+		default:
+		}
+
+		ctx, l := logger.WithTags(ctx, logger.Tag("file", f.Name()))
+		l.Debug("Reading file", f.Name())
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".csv") {
+			if strings.HasPrefix(f.Name(), ".") { // lockfiles
+				continue
+			}
+			l.Warning("Format/ReadDir", "noncsv file founded "+f.Name())
+			continue
+		}
+		if !conf.Watcher.Ready(f) {
+			l.Debug("ReadDir", "file is not ready yet, skipped")
+			continue
+		}
+		file, err := os.Open(conf.ProductsCsvPath + f.Name())
+		if err != nil {
+			l.Error("os.Open", err)
+			return processed
+		}
+		//defer file.Close()
+
+		// GET SUPPLIER
+		r := csv.NewReader(file)
+		r.Comma = []rune(conf.SuppliersCsvFormat.Delimeter)[0]
+		r.LazyQuotes = true
+		r.ReuseRecord = true
+		_, err = r.Read()
+		if err != nil {
+			l.Error("csv.Reader.Read", err)
+			file.Close()
+			conf.fail(ctx, l, conf.ProductsCsvPath, f.Name(), "read header: "+err.Error())
+			continue
+		}
+
+		sup, err := rep.GetSupplierByFilename(ctx, f.Name())
+		if err != nil {
+			l.Error("GetSupplierByFilename", err)
+			file.Close()
+			// other errors are db's ones, file will be retried
+			if errors.Is(err, ErrNotExists) {
+				conf.fail(ctx, l, conf.ProductsCsvPath, f.Name(), "no supplier with this filename")
+			}
+			continue
+		}
+		ctx, l = logger.WithTags(ctx, logger.Tag("supplier", sup.Name))
+
+		// CREATE UPLOAD
+		manifestid, err := conf.Manifest.ID(f.Name())
+		if err != nil && !errors.Is(err, manifest.ErrUnknownFile) {
+			l.Error("Manifest.ID", err)
+		}
+		uploadid, err := rep.CreateUpload(ctx, manifestid)
+		if err != nil {
+			l.Error("CreateUpload", err)
+			file.Close()
+			return processed
+		}
+		ctx, l = logger.WithTags(ctx, logger.Tag("upload", strconv.Itoa(uploadid)))
+		if manifestid != "" {
+			l.Debug("Upload", "manifest entry "+manifestid)
+		}
+
+		var sucs, all int
+
+		// PRODUCTS LOOP
+		// queries are made with ctx, so on its done file is left for next run instead of rejecting its rows
+		for ctx.Err() == nil {
+			row, err := r.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if errors.Is(err, csv.ErrFieldCount) {
+					metrics.RowsRead.Inc(stagename, sup.Name)
+					metrics.RowsRejected.Inc(stagename, sup.Name, "field_count")
+					continue
+				}
+				l.Error("csv.Reader.Read", err)
+				file.Close()
+				conf.fail(ctx, l, conf.ProductsCsvPath, f.Name(), "read: "+err.Error())
+				continue fileloop
+			}
+			all++
+			metrics.RowsRead.Inc(stagename, sup.Name)
+
+			// GET BRAND ID
+			normbrand := normstring(row[conf.SuppliersCsvFormat.BrandCol])
+			if normbrand == "" {
+				normbrand = "NO_BRAND"
+			}
+			brandid, brandnorms, err := rep.GetBrandIdByNorm(ctx, normstring(row[conf.SuppliersCsvFormat.BrandCol]))
+			if err != nil {
+				if errors.Is(err, ErrNotExists) {
+					l.Debug("GetBrandIdByNorm", "not found brand, creating one", logger.String("brand", row[conf.SuppliersCsvFormat.BrandCol]))
+					brandnorms = []string{normstring(row[conf.SuppliersCsvFormat.BrandCol])}
+					if brandid, err = rep.CreateBrand(ctx, strings.TrimSpace(row[conf.SuppliersCsvFormat.BrandCol]), brandnorms); err != nil {
+						l.Error("GetBrandIdByNorm/CreateBrand", err)
+						metrics.RowsRejected.Inc(stagename, sup.Name, "brand")
+						continue
+					}
+				} else {
+					l.Error("GetBrandIdByNorm", err, logger.String("brandnorm", normstring(row[conf.SuppliersCsvFormat.BrandCol])))
+					metrics.RowsRejected.Inc(stagename, sup.Name, "brand")
+					continue
+				}
+			}
+
+			// CREATING ARTICUL
+			normart := normstring(row[conf.SuppliersCsvFormat.ArticulCol])
+			alts := make([]string, 0)
+			for i := 0; i < len(brandnorms); i++ {
+				if artmap, ok := altarts[brandnorms[i]]; ok {
+					if a, ok := artmap[normart]; ok {
+						normart, alts = a.primary, a.alt
+					}
+					break
+				}
+			}
+
+			if err = rep.UpsertArticul(ctx, normart, brandid, alts); err != nil {
+				l.Error("UpsertArticul", err, logger.String("normart", normart))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "articul")
+				continue
+			}
+
+			// GET SHIT
+			price, err := strconv.ParseFloat(strings.Replace(row[conf.SuppliersCsvFormat.PriceCol], ",", ".", 1), 32)
+			if err != nil {
+				l.Error("ParseFloat/Price", err, logger.String("price", row[conf.SuppliersCsvFormat.PriceCol]), logger.String("product", row[conf.SuppliersCsvFormat.NameCol]))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "price")
+				continue
+			}
+			quantity, err := strconv.Atoi(row[conf.SuppliersCsvFormat.QuantityCol])
+			if err != nil {
+				l.Error("Atoi/Quantity", err, logger.String("quantity", row[conf.SuppliersCsvFormat.QuantityCol]), logger.String("product", row[conf.SuppliersCsvFormat.NameCol]))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "quantity")
+				continue
+			}
+			rest, err := strconv.Atoi(strings.Trim(row[conf.SuppliersCsvFormat.RestCol], "<>~"))
+			if err != nil {
+				l.Error("Atoi/Rest", err, logger.String("rest", row[conf.SuppliersCsvFormat.RestCol]), logger.String("product", row[conf.SuppliersCsvFormat.NameCol]))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "rest")
+				continue
+			}
+
+			// CREATE PRODUCT
+
+			prodid, err := rep.GetOrCreateProduct(ctx, normart, sup.id, brandid, row[conf.SuppliersCsvFormat.NameCol], row[conf.SuppliersCsvFormat.PartnumCol], quantity)
+			if err != nil {
+				l.Error("GetOrCreateProduct", err, logger.String("product", row[conf.SuppliersCsvFormat.NameCol]))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "product")
+				continue
+			}
+			if err = rep.UpsertActualPrice(ctx, prodid, uploadid, float32(price), rest); err != nil {
+				l.Error("UpsertActualPrice", err, logger.String("product", row[conf.SuppliersCsvFormat.NameCol]), logger.Int("productid", prodid))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "actual_price")
+				continue
+			}
+			if err = rep.InsertHistoryPrice(ctx, prodid, uploadid, float32(price), rest); err != nil {
+				l.Error("InsertHistoryPrice", err, logger.String("product", row[conf.SuppliersCsvFormat.NameCol]), logger.Int("productid", prodid))
+				metrics.RowsRejected.Inc(stagename, sup.Name, "history_price")
+				continue
+			}
+			sucs++
+		}
+		if ctx.Err() != nil {
+			l.Warning("Upload", "context done, file is left for next run", logger.Int("added", sucs), logger.Int("rows", all))
+			file.Close()
+			return processed
+		}
+		l.Debug("Upload", "successfully added", logger.Int("added", sucs), logger.Int("rows", all))
+		metrics.FilesProcessed.Inc(stagename, sup.Name)
+		processed++
+		file.Close()
+		if err = conf.Manifest.Transit(ctx, f.Name(), manifest.Uploaded, stagename); err != nil {
+			l.Error("Manifest.Transit", err)
+		}
+
+		if conf.RemoveProcessed {
+			if err = lease.Check(); err != nil {
+				l.Error("Lease", errors.New("lock lost, stopping without removing file: "+err.Error()))
+				return processed
+			}
+			if err = os.Remove(conf.ProductsCsvPath + f.Name()); err != nil {
+				l.Error("Remove", err)
+			}
+			l.Debug("Remove", "file removed")
+		}
+
+		// UPDATE OUT OF STOCK PRODUCTS
+		n, err := rep.UpdateOutOfStock(ctx, sup.id, uploadid)
+		if err != nil {
+			l.Error("UpdateOutOfStock", err)
+		} else {
+			l.Debug("UpdateOutOfStock", "rest set to zero", logger.Int("products", n))
+		}
+	}
+	return processed
+}
+
+// fails file's manifest entry and moves file from dir into stage's quarantine dir
+func (conf *Config) fail(ctx context.Context, l logger.Logger, dir, file string, reason string) {
+	if err := quarantine.Put(ctx, conf.Manifest, conf.QuarantinePath, dir, file, stagename, reason); err != nil {
+		l.Error("Quarantine", err)
+		return
+	}
+	if conf.QuarantinePath != "" {
+		l.Warning("Quarantine", "file moved into quarantine: "+file+", reason: "+reason)
+	}
+}
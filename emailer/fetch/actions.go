@@ -0,0 +1,62 @@
+package fetch
+
+import (
+	"errors"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// actions on processed messages: adding flags (e.g. \Seen or custom keyword) and moving into other mailbox.
+// Connection is busy while fetching, so actions are collected and applied after fetch is done
+
+type actions struct {
+	flags map[string]*imap.SeqSet // uids by flag
+	moves map[string]*imap.SeqSet // uids by destination mailbox
+}
+
+func newActions() *actions {
+	return &actions{flags: make(map[string]*imap.SeqSet), moves: make(map[string]*imap.SeqSet)}
+}
+
+func (a *actions) flag(uid uint32, flags ...string) {
+	for _, f := range flags {
+		if a.flags[f] == nil {
+			a.flags[f] = new(imap.SeqSet)
+		}
+		a.flags[f].AddNum(uid)
+	}
+}
+
+// message is moved only once, first destination wins
+func (a *actions) move(uid uint32, mailbox string) {
+	for _, uids := range a.moves {
+		if uids.Contains(uid) {
+			return
+		}
+	}
+	if a.moves[mailbox] == nil {
+		a.moves[mailbox] = new(imap.SeqSet)
+	}
+	a.moves[mailbox].AddNum(uid)
+}
+
+// flags are added before moving, so moved messages keep them. Moves into selected mailbox are skipped.
+// Returns the first error, other actions are still applied
+func (a *actions) apply(c *client.Client, selected string) error {
+	var firsterr error
+	for f, uids := range a.flags {
+		if err := c.UidStore(uids, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{f}, nil); err != nil && firsterr == nil {
+			firsterr = errors.New("add flag " + f + " err: " + err.Error())
+		}
+	}
+	for mailbox, uids := range a.moves {
+		if mailbox == selected {
+			continue
+		}
+		if err := c.UidMove(uids, mailbox); err != nil && firsterr == nil {
+			firsterr = errors.New("move into " + mailbox + " err: " + err.Error())
+		}
+	}
+	return firsterr
+}
@@ -0,0 +1,135 @@
+package fetch
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// position of mailbox sync. Messages with uids above LastUid are new, while mailbox's
+// uidvalidity equals UidValidity. When it changes, uids are meaningless, and messages
+// with internal dates after LastDate are fetched again.
+// Every (account, mailbox) has its own cursor in cursors dir
+
+const (
+	cursorsdir       = "mailcursors"     // in working dir
+	legacycursorfile = "mailcursor.json" // cursor of single mailbox, is migrated
+	legacyfile       = "lastmessage"     // seqnum of last message of single mailbox, is migrated
+)
+
+// mailbox name is escaped, as it may contain slashes
+func cursorPath(account, mailbox string) string {
+	return filepath.Join(cursorsdir, account, url.PathEscape(mailbox)+".json")
+}
+
+type cursor struct {
+	UidValidity uint32
+	LastUid     uint32
+	LastDate    time.Time // internal date of latest processed message
+}
+
+// returns nil cursor, if there is no cursor file
+func loadCursor(path string) (*cursor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cur := &cursor{}
+	if err = json.Unmarshal(data, cur); err != nil {
+		return nil, errors.New("decode cursor " + path + " err: " + err.Error())
+	}
+	return cur, nil
+}
+
+// cursor file is replaced atomically, so it is never half-written
+func (cur *cursor) save(path string) error {
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// message is processed
+func (cur *cursor) advance(msg *imap.Message) {
+	if msg.Uid > cur.LastUid {
+		cur.LastUid = msg.Uid
+	}
+	if msg.InternalDate.After(cur.LastDate) {
+		cur.LastDate = msg.InternalDate
+	}
+}
+
+// returns legacy cursor or converts seqnum from legacy file into cursor of selected mailbox.
+// Returns nil cursor, if there are no legacy files
+func migrateLegacy(c *client.Client, mbox *imap.MailboxStatus) (*cursor, error) {
+	if cur, err := loadCursor(legacycursorfile); cur != nil || err != nil {
+		return cur, err
+	}
+	data, err := os.ReadFile(legacyfile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	seqnum, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return nil, errors.New("parse " + legacyfile + " err: " + err.Error())
+	}
+	cur := &cursor{UidValidity: mbox.UidValidity}
+	switch {
+	case seqnum == 0:
+		return cur, nil
+	case seqnum >= uint64(mbox.Messages):
+		// all messages in mailbox were processed
+		if mbox.UidNext > 0 {
+			cur.LastUid = mbox.UidNext - 1
+		}
+		cur.LastDate = time.Now()
+		return cur, nil
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uint32(seqnum))
+	messages := make(chan *imap.Message, 1)
+	if err = c.Fetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate}, messages); err != nil {
+		return nil, err
+	}
+	for msg := range messages {
+		cur.advance(msg)
+	}
+	return cur, nil
+}
@@ -0,0 +1,231 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+// logged in client of in-memory server. Its INBOX has one message from contact@example.org
+func testClient(t *testing.T) *client.Client {
+	t.Helper()
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testMessage(from, attachment string) *bytes.Buffer {
+	b := &bytes.Buffer{}
+	b.WriteString("From: " + from + "\r\nTo: prices@example.org\r\nSubject: price\r\nDate: Wed, 11 May 2016 14:31:59 +0000\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=BB\r\n\r\n" +
+		"--BB\r\nContent-Type: text/plain\r\n\r\nprice attached\r\n" +
+		"--BB\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"" + attachment + "\"\r\n\r\ndata\r\n--BB--\r\n")
+	return b
+}
+
+// legacy cursor files are read from working dir
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestCursorSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		data    string // written into cursor file, if not empty
+		save    *cursor
+		want    *cursor
+		wanterr bool
+	}{
+		{"no file", "", nil, nil, false},
+		{"saved", "", &cursor{UidValidity: 3, LastUid: 42, LastDate: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}, &cursor{UidValidity: 3, LastUid: 42, LastDate: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}, false},
+		{"broken", "{\"UidValidity\":", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name, "INBOX.json")
+			if tt.data != "" {
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.save != nil {
+				if err := tt.save.save(path); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+					t.Fatal("temp file is left")
+				}
+			}
+			cur, err := loadCursor(path)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("loadCursor() err = %v, want err %v", err, tt.wanterr)
+			}
+			if (cur == nil) != (tt.want == nil) || (cur != nil && (cur.UidValidity != tt.want.UidValidity || cur.LastUid != tt.want.LastUid || !cur.LastDate.Equal(tt.want.LastDate))) {
+				t.Fatalf("loadCursor() = %+v, want %+v", cur, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorPath(t *testing.T) {
+	tests := []struct {
+		account, mailbox, want string
+	}{
+		{"mailru", "INBOX", filepath.Join(cursorsdir, "mailru", "INBOX.json")},
+		{"mailru", "Prices/Suppliers", filepath.Join(cursorsdir, "mailru", "Prices%2FSuppliers.json")},
+		{"yandex", "Я - Прайсы", filepath.Join(cursorsdir, "yandex", "%D0%AF%20-%20%D0%9F%D1%80%D0%B0%D0%B9%D1%81%D1%8B.json")},
+	}
+	for _, tt := range tests {
+		if got := cursorPath(tt.account, tt.mailbox); got != tt.want {
+			t.Errorf("cursorPath(%q, %q) = %q, want %q", tt.account, tt.mailbox, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMailbox(t *testing.T) {
+	chdir(t, t.TempDir())
+	c := testClient(t)
+	downloads := t.TempDir() + "/"
+	sups := config.Suppliers{{Name: "sup", Email: "sup@example.org", MailFileNamePattern_Prefixes: []string{"price"}, MailFileNamePattern_Suffixes: []string{".zip"}}}
+	l := logger.FromContext(context.Background())
+	appendMsg := func(mailbox, from, attachment string) {
+		if err := c.Append(mailbox, nil, time.Now(), testMessage(from, attachment)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		mailbox  string
+		legacy   bool
+		backfill bool
+		prepare  func(cursorpath string)
+		saved    []string
+	}{
+		{"no cursor, messages after last one", "INBOX", false, false, func(string) {
+			appendMsg("INBOX", "sup@example.org", "price0.zip")
+		}, nil},
+		{"no new messages", "INBOX", false, false, func(string) {}, nil},
+		{"new messages after cursor", "INBOX", false, false, func(string) {
+			appendMsg("INBOX", "other@example.org", "price2.zip")
+			appendMsg("INBOX", "sup@example.org", "price3.zip")
+		}, []string{"price3.zip"}},
+		{"uidvalidity changed, messages after last date", "INBOX", false, false, func(cursorpath string) {
+			cur, err := loadCursor(cursorpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cur.UidValidity++
+			if err = cur.save(cursorpath); err != nil {
+				t.Fatal(err)
+			}
+			// internal dates are in seconds
+			time.Sleep(time.Millisecond * 1100)
+			appendMsg("INBOX", "sup@example.org", "price4.zip")
+		}, []string{"price4.zip"}},
+		{"no cursor, backfill", "Backfill", false, true, func(string) {
+			if err := c.Create("Backfill"); err != nil {
+				t.Fatal(err)
+			}
+			appendMsg("Backfill", "sup@example.org", "price1.zip")
+		}, []string{"price1.zip"}},
+		{"legacy seqnum migrated", "Legacy", true, false, func(string) {
+			if err := c.Create("Legacy"); err != nil {
+				t.Fatal(err)
+			}
+			appendMsg("Legacy", "sup@example.org", "price5.zip")
+			appendMsg("Legacy", "sup@example.org", "price6.zip")
+			if err := os.WriteFile(legacyfile, []byte("1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"price6.zip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := &config.ImapAccount{Name: "acc", ImapBackfill: tt.backfill}
+			cursorpath := cursorPath(acc.Name, tt.mailbox)
+			tt.prepare(cursorpath)
+			before, _ := os.ReadDir(downloads)
+			n, err := checkMailbox(context.Background(), l, c, acc, tt.mailbox, cursorpath, downloads, sups, nil, tt.legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.saved) {
+				t.Fatalf("saved %d attachments, want %d", n, len(tt.saved))
+			}
+			after, _ := os.ReadDir(downloads)
+			if len(after)-len(before) != len(tt.saved) {
+				t.Fatalf("%d files appeared, want %v", len(after)-len(before), tt.saved)
+			}
+			for _, name := range tt.saved {
+				if _, err := os.Stat(downloads + name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mbox := c.Mailbox()
+			cur, err := loadCursor(cursorpath)
+			if err != nil || cur == nil {
+				t.Fatalf("loadCursor() = %v, %v", cur, err)
+			}
+			if cur.UidValidity != mbox.UidValidity || cur.LastUid != mbox.UidNext-1 {
+				t.Fatalf("cursor %+v, want uidvalidity %d and last uid %d", cur, mbox.UidValidity, mbox.UidNext-1)
+			}
+		})
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	date := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		msg  imap.Message
+		want cursor
+	}{
+		{"newer", imap.Message{Uid: 10, InternalDate: date.Add(time.Hour)}, cursor{LastUid: 10, LastDate: date.Add(time.Hour)}},
+		{"older uid", imap.Message{Uid: 3, InternalDate: date.Add(time.Hour)}, cursor{LastUid: 5, LastDate: date.Add(time.Hour)}},
+		{"older date", imap.Message{Uid: 10, InternalDate: date.Add(-time.Hour)}, cursor{LastUid: 10, LastDate: date}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur := cursor{LastUid: 5, LastDate: date}
+			cur.advance(&tt.msg)
+			if cur.LastUid != tt.want.LastUid || !cur.LastDate.Equal(tt.want.LastDate) {
+				t.Fatalf("advanced to %+v, want %+v", cur, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+)
+
+// fetch stage: downloads suppliers' attachments from mail into downloads dir
+
+const stagename = "emailer"
+
+var lockopts = &locker.Options{Timeout: time.Second * 15, MinBackoff: time.Millisecond * 500, MaxBackoff: time.Second * 5}
+
+// locks downloadspath and checks mail of all accounts concurrently. Returns number of saved attachments.
+// Saved attachments are received into mf, if it is not nil
+func Fetch(ctx context.Context, l logger.Logger, accs []*config.ImapAccount, downloadspath string, sups config.Suppliers, mf *manifest.Manifest) int {
+	return fetch(ctx, l, accs, nil, downloadspath, sups, mf)
+}
+
+// mailboxes, that skip returns true for, are not checked
+func fetch(ctx context.Context, l logger.Logger, accs []*config.ImapAccount, skip func(account, mailbox string) bool, downloadspath string, sups config.Suppliers, mf *manifest.Manifest) int {
+	defer metrics.JobDuration.Since(time.Now(), stagename)
+	mailboxes := make([][]string, len(accs))
+	var total int
+	for i, acc := range accs {
+		for _, mailbox := range acc.ImapMailboxes {
+			if skip == nil || !skip(acc.Name, mailbox) {
+				mailboxes[i] = append(mailboxes[i], mailbox)
+			}
+		}
+		total += len(mailboxes[i])
+	}
+	if total == 0 {
+		return 0
+	}
+	lease := lockdir(ctx, l, downloadspath)
+	if lease == nil {
+		return 0
+	}
+	defer lease.Unlock()
+
+	legacyacc, legacymbox := legacyMailbox(accs)
+	var wg sync.WaitGroup
+	var saved int64
+	for i, acc := range accs {
+		if len(mailboxes[i]) == 0 {
+			continue
+		}
+		var legacy string
+		if acc.Name == legacyacc {
+			legacy = legacymbox
+		}
+		wg.Add(1)
+		go func(acc *config.ImapAccount, mailboxes []string, legacy string) {
+			defer wg.Done()
+			al := l.NewSubLogger(logger.Tag("account", acc.Name))
+			n, err := CheckMail(ctx, al, acc, mailboxes, downloadspath, sups, mf, legacy)
+			if err != nil {
+				al.Error("checkMail", err)
+			}
+			atomic.AddInt64(&saved, int64(n))
+		}(acc, mailboxes[i], legacy)
+	}
+	wg.Wait()
+	return int(saved)
+}
+
+// first mailbox of first account gets cursor of legacy single mailbox sync
+func legacyMailbox(accs []*config.ImapAccount) (account, mailbox string) {
+	if len(accs) == 0 || len(accs[0].ImapMailboxes) == 0 {
+		return "", ""
+	}
+	return accs[0].Name, accs[0].ImapMailboxes[0]
+}
+
+// returns nil, if dir was not locked. Lease is renewed, as big attachments may be downloaded for long
+func lockdir(ctx context.Context, l logger.Logger, path string) *locker.Lease {
+	lockstart := time.Now()
+	lease, err := locker.LockDirLease(ctx, path, lockopts)
+	metrics.LockWait.Since(lockstart, stagename)
+	if err != nil {
+		if errors.Is(err, locker.ErrLocked) {
+			l.Error("LockDir", errors.New("download dir locked, timeout reached"))
+		} else {
+			l.Error("LockDir", err)
+		}
+		return nil
+	}
+	return lease
+}
@@ -0,0 +1,217 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+)
+
+// idle mode: every mailbox holds its own connection and waits with IMAP IDLE, new messages are
+// fetched as soon as server announces them. Broken connection is reconnected with backoff.
+// Mailboxes, that are not idling now (server has no IDLE or connection is down), are left to timer
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute * 5
+	// IDLE is restarted with mailbox sync before servers' 30 minutes inactivity logout,
+	// so messages, that arrived while syncing, are not missed for long
+	idleRestart = time.Minute * 20
+)
+
+var errNoIdle = errors.New("server does not support IDLE")
+
+type Idling struct {
+	accs  []*config.ImapAccount
+	mux   sync.Mutex
+	live  map[string]bool // by cursor paths of mailboxes, that are idling now
+	saved chan struct{}
+}
+
+// starts idling of all accounts' mailboxes until ctx is done. Suppliers are loaded before every sync
+func Idle(ctx context.Context, l logger.Logger, accs []*config.ImapAccount, downloadspath string, suppliers func() (config.Suppliers, error), mf *manifest.Manifest) *Idling {
+	in := &Idling{accs: accs, live: make(map[string]bool), saved: make(chan struct{}, 1)}
+	legacyacc, legacymbox := legacyMailbox(accs)
+	for _, acc := range accs {
+		for _, mailbox := range acc.ImapMailboxes {
+			ml := l.NewSubLogger(logger.Tag("account", acc.Name), logger.Tag("mailbox", mailbox))
+			go in.run(ctx, ml, acc, mailbox, acc.Name == legacyacc && mailbox == legacymbox, downloadspath, suppliers, mf)
+		}
+	}
+	return in
+}
+
+// signals, when idling mailboxes saved attachments
+func (in *Idling) Saved() <-chan struct{} {
+	return in.saved
+}
+
+// checks mailboxes, that are not idling now, as Fetch does
+func (in *Idling) Fetch(ctx context.Context, l logger.Logger, downloadspath string, sups config.Suppliers, mf *manifest.Manifest) int {
+	return fetch(ctx, l, in.accs, in.isLive, downloadspath, sups, mf)
+}
+
+func (in *Idling) isLive(account, mailbox string) bool {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	return in.live[cursorPath(account, mailbox)]
+}
+
+func (in *Idling) setLive(account, mailbox string, live bool) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	in.live[cursorPath(account, mailbox)] = live
+}
+
+// reconnects with backoff until ctx is done or server turns out to have no IDLE
+func (in *Idling) run(ctx context.Context, l logger.Logger, acc *config.ImapAccount, mailbox string, legacy bool, downloadspath string, suppliers func() (config.Suppliers, error), mf *manifest.Manifest) {
+	l.Info("Idle", "started")
+	backoff := minReconnectBackoff
+	for {
+		synced, err := in.idle(ctx, l, acc, mailbox, legacy, downloadspath, suppliers, mf)
+		in.setLive(acc.Name, mailbox, false)
+		if ctx.Err() != nil {
+			l.Info("Idle", "context done, exiting")
+			return
+		}
+		if errors.Is(err, errNoIdle) {
+			l.Warning("Idle", err.Error()+", mailbox is left to timer")
+			return
+		}
+		if synced {
+			backoff = minReconnectBackoff
+		}
+		l.Error("Idle", errors.New(err.Error()+", reconnecting in "+backoff.String()))
+		select {
+		case <-ctx.Done():
+			l.Info("Idle", "context done, exiting")
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// holds one connection: syncs mailbox, then idles and syncs on every mailbox change.
+// Returns true, if mailbox was synced at least once, so connection was good
+func (in *Idling) idle(ctx context.Context, l logger.Logger, acc *config.ImapAccount, mailbox string, legacy bool, downloadspath string, suppliers func() (config.Suppliers, error), mf *manifest.Manifest) (bool, error) {
+	c, imapdebug, err := dial(l, acc)
+	if err != nil {
+		return false, err
+	}
+	defer imapdebug.Flush()
+	defer c.Logout()
+	if ok, err := c.Support("IDLE"); err != nil {
+		return false, err
+	} else if !ok {
+		return false, errNoIdle
+	}
+
+	// reader of connection blocks on updates, so they are always drained
+	updates := make(chan client.Update, 16)
+	c.Updates = updates
+	changed := make(chan struct{}, 1)
+	var expunged int64
+	go func() {
+		for {
+			select {
+			case <-c.LoggedOut():
+				return
+			case u := <-updates:
+				switch u.(type) {
+				case *client.ExpungeUpdate:
+					atomic.AddInt64(&expunged, 1)
+				case *client.MailboxUpdate:
+				default:
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	// messages and expunged at last sync, so changes made by sync itself (e.g. select's EXISTS) are skipped
+	var messages uint32
+	var lastexpunged int64
+	syncMailbox := func() error {
+		sups, err := suppliers()
+		if err != nil {
+			return err
+		}
+		lease := lockdir(ctx, l, downloadspath)
+		if lease == nil {
+			return errors.New("download dir is not locked")
+		}
+		start := time.Now()
+		n, err := checkMailbox(ctx, l, c, acc, mailbox, cursorPath(acc.Name, mailbox), downloadspath, sups, mf, legacy)
+		lease.Unlock()
+		metrics.JobDuration.Since(start, stagename)
+		if n > 0 {
+			select {
+			case in.saved <- struct{}{}:
+			default:
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if mbox := c.Mailbox(); mbox != nil {
+			messages = mbox.Messages
+		}
+		lastexpunged = atomic.LoadInt64(&expunged)
+		return nil
+	}
+
+	if err = syncMailbox(); err != nil {
+		return false, err
+	}
+	in.setLive(acc.Name, mailbox, true)
+	l.Debug("Idle", "idling")
+	restart := time.NewTicker(idleRestart)
+	defer restart.Stop()
+	for {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Idle(stop, &client.IdleOptions{LogoutTimeout: -1})
+		}()
+		var force bool
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			return true, ctx.Err()
+		case err := <-done:
+			if err == nil {
+				err = errors.New("idle stopped")
+			}
+			return true, err
+		case <-restart.C:
+			force = true
+		case <-changed:
+		}
+		close(stop)
+		if err := <-done; err != nil {
+			return true, err
+		}
+		if mbox := c.Mailbox(); !force && mbox != nil && mbox.Messages == messages && atomic.LoadInt64(&expunged) == lastexpunged {
+			continue
+		}
+		l.Debug("Idle", "syncing")
+		if err := syncMailbox(); err != nil {
+			return true, err
+		}
+	}
+}
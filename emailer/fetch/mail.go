@@ -0,0 +1,383 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+
+	"io"
+
+	"os"
+
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/logs/encode"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func init() {
+	message.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if charset == "windows-1251" {
+			decoder := charmap.Windows1251.NewDecoder()
+			r := decoder.Reader(input)
+			return r, nil
+		}
+		return input, nil
+	}
+}
+
+// checks given account's mailboxes one by one, every mailbox has its own cursor. Returns number of saved attachments.
+// Cursor of legacy single mailbox sync is migrated into legacy mailbox's one, if it is among mailboxes
+func CheckMail(ctx context.Context, l logger.Logger, acc *config.ImapAccount, mailboxes []string, downloadspath string, suppliers config.Suppliers, mf *manifest.Manifest, legacy string) (int, error) {
+	var saved int
+	c, imapdebug, err := dial(l, acc)
+	if err != nil {
+		return saved, err
+	}
+	// Don't forget to logout
+	defer c.Logout()
+	defer imapdebug.Flush()
+
+	for _, mailbox := range mailboxes {
+		ml := l.NewSubLogger(logger.Tag("mailbox", mailbox))
+		n, err := checkMailbox(ctx, ml, c, acc, mailbox, cursorPath(acc.Name, mailbox), downloadspath, suppliers, mf, mailbox == legacy)
+		saved += n
+		if err != nil {
+			ml.Error("checkMailbox", err)
+		}
+	}
+	return saved, nil
+}
+
+// connects to account's server and logs in. Imap debug writer is to be flushed after logout
+func dial(l logger.Logger, acc *config.ImapAccount) (*client.Client, *logger.LogsWriterAdapter, error) {
+	l.Debug("checkMail", "Connecting to server...")
+	// Connect to server
+	c, err := client.DialTLS(acc.ImapAddr, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.Debug("checkMail", "Connected")
+
+	// Login
+	if err := c.Login(acc.ImapLogin, acc.ImapPassword); err != nil {
+		c.Logout()
+		return nil, nil, err
+	}
+	l.Debug("checkMail", "Logged in")
+	// set after login, so credentials are not logged
+	imapdebug := logger.NewWriter(l.NewSubLogger("imap"), encode.DebugLevel, "Debug")
+	c.SetDebug(imapdebug)
+	return c, imapdebug, nil
+}
+
+// fetches messages, that are new since cursor in cursorpath, from mailbox and saves suppliers' attachments.
+// Cursor is saved after every processed message. New cursor starts after mailbox's last message, unless account's ImapBackfill is set
+func checkMailbox(ctx context.Context, l logger.Logger, c *client.Client, acc *config.ImapAccount, mailbox, cursorpath, downloadspath string, suppliers config.Suppliers, mf *manifest.Manifest, legacy bool) (int, error) {
+	var saved int
+	mbox, err := c.Select(mailbox, false)
+	if err != nil {
+		return saved, err
+	}
+	l.Debug("checkMail", "Flags for "+mailbox+": ["+strings.Join(mbox.Flags, ", ")+"]")
+
+	cur, err := loadCursor(cursorpath)
+	if err != nil {
+		return saved, err
+	}
+	if cur == nil {
+		if legacy {
+			if cur, err = migrateLegacy(c, mbox); err != nil {
+				return saved, err
+			}
+		}
+		if cur == nil {
+			if acc.ImapBackfill || mbox.UidNext == 0 {
+				l.Warning("checkMail", "no cursor "+cursorpath+", all messages will be fetched")
+				cur = &cursor{UidValidity: mbox.UidValidity}
+			} else {
+				l.Warning("checkMail", "no cursor "+cursorpath+", only new messages will be fetched, uidnext "+strconv.FormatUint(uint64(mbox.UidNext), 10))
+				cur = &cursor{UidValidity: mbox.UidValidity, LastUid: mbox.UidNext - 1, LastDate: time.Now()}
+			}
+		} else {
+			l.Info("checkMail", "legacy cursor migrated into "+cursorpath+", last uid "+strconv.FormatUint(uint64(cur.LastUid), 10))
+		}
+		if err = cur.save(cursorpath); err != nil {
+			return saved, err
+		}
+	}
+
+	// since is set on resync, messages are filtered by internal date then
+	var since time.Time
+	seqset := new(imap.SeqSet)
+	if cur.UidValidity != mbox.UidValidity {
+		l.Warning("checkMail", "uidvalidity changed from "+strconv.FormatUint(uint64(cur.UidValidity), 10)+" to "+strconv.FormatUint(uint64(mbox.UidValidity), 10)+", resyncing messages after "+cur.LastDate.String())
+		since = cur.LastDate
+		criteria := imap.NewSearchCriteria()
+		if !since.IsZero() {
+			// SINCE compares dates in server's timezone, so search is a day wider and messages are filtered by exact internal dates
+			criteria.Since = since.AddDate(0, 0, -1)
+		}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return saved, err
+		}
+		cur = &cursor{UidValidity: mbox.UidValidity, LastDate: since}
+		seqset.AddNum(uids...)
+	} else if mbox.UidNext == 0 || cur.LastUid+1 < mbox.UidNext {
+		// server returns last message for uid range above all uids, so the range is requested only if there are new ones
+		seqset.AddRange(cur.LastUid+1, 0)
+	}
+	l.Debug("checkMail", "Total messages: "+strconv.FormatUint(uint64(mbox.Messages), 10)+", load from uid "+strconv.FormatUint(uint64(cur.LastUid+1), 10))
+
+	if !seqset.Empty() {
+		n, err := fetchMessages(ctx, l, c, seqset, cur, since, acc, mailbox, cursorpath, downloadspath, suppliers, mf)
+		saved += n
+		if err != nil {
+			return saved, err
+		}
+	}
+	// every message below uidnext was fetched
+	if mbox.UidNext > cur.LastUid+1 {
+		cur.LastUid = mbox.UidNext - 1
+	}
+	if err = cur.save(cursorpath); err != nil {
+		return saved, err
+	}
+
+	l.Debug("checkMail", "Done!")
+	return saved, nil
+}
+
+// fetches messages by uids in seqset, skipping ones not after since (if set) and ones at or below cursor.
+// Then applies suppliers' actions to messages, which attachments were saved, and moves messages of suppliers
+// without suitable attachments into account's unmatched mailbox
+func fetchMessages(ctx context.Context, l logger.Logger, c *client.Client, seqset *imap.SeqSet, cur *cursor, since time.Time, acc *config.ImapAccount, mailbox, cursorpath, downloadspath string, suppliers config.Suppliers, mf *manifest.Manifest) (int, error) {
+	var saved int
+	messages := make(chan *imap.Message, 30)
+	done := make(chan error, 1)
+	// peek, so messages are not seen until their suppliers' flags are added
+	section := imap.BodySectionName{Peek: true}
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchEnvelope, section.FetchItem()}, messages)
+	}()
+	acts := newActions()
+	// go-imap's reader blocks on sending messages, so on error they are drained and fetch is waited for,
+	// otherwise connection hangs. Collected actions are applied on errors too, as their messages
+	// are already behind cursor and are not fetched again
+	finish := func(err error) (int, error) {
+		for range messages {
+		}
+		if ferr := <-done; err == nil {
+			err = ferr
+		}
+		if aerr := acts.apply(c, mailbox); aerr != nil {
+			l.Error("Actions", aerr)
+		}
+		return saved, err
+	}
+	for msg := range messages {
+		if msg.Uid <= cur.LastUid || (!since.IsZero() && !msg.InternalDate.After(since)) {
+			continue
+		}
+		metrics.MessagesFetched.Inc(acc.Name, mailbox)
+		//log.Println("* "+msg.Envelope.Subject, msg.Envelope.From[0].Address(), len(msg.Items), len(msg.Body))
+		cur_sups, rules := getSupsByMail(suppliers, msg.Envelope)
+		if len(cur_sups) == 0 {
+			cur.advance(msg)
+			if err := cur.save(cursorpath); err != nil {
+				return finish(err)
+			}
+			continue
+		}
+		r := msg.GetBody(&section)
+		if r == nil {
+			return finish(errors.New("server didn't returned message body"))
+		}
+
+		// Create a new mail reader
+		mr, err := mail.CreateReader(r)
+		if err != nil {
+			return finish(err)
+		}
+
+		// Print some info about the message
+		header := mr.Header
+		if date, err := header.Date(); err == nil {
+			l.Debug("checkMail", "Date: "+date.String())
+		}
+		if from, err := header.AddressList("From"); err == nil {
+			var frs string
+			for _, fr := range from {
+				frs += " " + fr.String()
+			}
+			frs += "]"
+			l.Debug("checkMail", "From: ["+frs)
+		}
+		// if to, err := header.AddressList("To"); err == nil {
+		// 	log.Println("To:", to)
+		// }
+		if subject, err := header.Subject(); err == nil {
+			l.Debug("checkMail", "Subject: "+subject)
+		}
+
+		var has_suitabled int
+		var handledby []*config.Supplier
+		// Process each message's part
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return finish(err)
+			}
+
+			switch h := p.Header.(type) {
+			// case *mail.InlineHeader:
+			// 	// This is the message's text (can be plain-text or HTML)
+			// 	b, _ := ioutil.ReadAll(p.Body)
+			// 	log.Println("Got text: %v", string(b))
+			case *mail.AttachmentHeader:
+				// This is an attachment
+				filename, _ := h.Filename()
+				l.Debug("checkMail", "Got attachment: "+filename)
+				sup := suitableSupplier(cur_sups, filename)
+				if sup == nil {
+					l.Debug("checkMail", "Not suitable attachment: "+filename)
+					continue
+				}
+				has_suitabled++
+				l.Debug("checkMail", "Attachment "+filename+" of supplier "+sup.Name+", matched by "+rules[sup])
+				// Create file with attachment name
+				file, err := os.Create(downloadspath + filename)
+				if err != nil {
+					return finish(err)
+				}
+				// using io.Copy instead of io.ReadAll to avoid insufficient memory issues
+				size, err := io.Copy(file, p.Body)
+				if err != nil {
+					file.Close()
+					return finish(err)
+				}
+				file.Close()
+				l.Debug("checkMail", "Saved "+strconv.FormatInt(size, 10)+" bytes into "+filename)
+				metrics.FilesProcessed.Inc(stagename, sup.Name)
+				saved++
+				if !containsSupplier(handledby, sup) {
+					handledby = append(handledby, sup)
+				}
+				src := manifest.Source{Account: acc.Name, Mailbox: mailbox, From: fromAddress(msg.Envelope), Subject: msg.Envelope.Subject, Date: msg.Envelope.Date, Attachment: filename, Rule: rules[sup]}
+				if id, err := mf.Receive(ctx, filename, stagename, sup.Name, src); err != nil {
+					l.Error("Manifest.Receive", err)
+				} else if id != "" {
+					l.Debug("checkMail", "manifest entry "+id+" for "+filename)
+				}
+			}
+		}
+		if has_suitabled < 1 {
+			l.Warning("checkMail", "No suitabled attachments in message from: "+fromAddress(msg.Envelope))
+			if acc.ImapUnmatchedMailbox != "" {
+				acts.move(msg.Uid, acc.ImapUnmatchedMailbox)
+			}
+		}
+		for _, sup := range handledby {
+			acts.flag(msg.Uid, sup.AddFlags()...)
+			if sup.MailArchiveMailbox != "" {
+				acts.move(msg.Uid, sup.MailArchiveMailbox)
+			}
+		}
+		cur.advance(msg)
+		if err = cur.save(cursorpath); err != nil {
+			return finish(err)
+		}
+	}
+
+	return finish(nil)
+}
+
+func IsSupplierEmail(suppliers config.Suppliers, email string) bool {
+	email = strings.ToLower(email)
+	for _, s := range suppliers {
+		if _, ok := s.MatchAddress(email); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// returns suppliers of message and rules, that matched them, e.g. "reply-to domain y.ru, subject"
+func getSupsByMail(sups config.Suppliers, env *imap.Envelope) ([]*config.Supplier, map[*config.Supplier]string) {
+	res := make([]*config.Supplier, 0)
+	rules := make(map[*config.Supplier]string)
+	for i := 0; i < len(sups); i++ {
+		rule, ok := matchAddresses(sups[i], env)
+		if !ok || !sups[i].MatchSubject(env.Subject) {
+			continue
+		}
+		if sups[i].MailSubjectRegexp != "" {
+			rule += ", subject"
+		}
+		res = append(res, sups[i])
+		rules[sups[i]] = rule
+	}
+	return res, rules
+}
+
+// checks addresses of supplier's headers, first matched rule is returned with header's name
+func matchAddresses(sup *config.Supplier, env *imap.Envelope) (string, bool) {
+	for _, h := range sup.Headers() {
+		var addrs []*imap.Address
+		switch h {
+		case config.HeaderFrom:
+			addrs = env.From
+		case config.HeaderSender:
+			addrs = env.Sender
+		case config.HeaderReplyTo:
+			addrs = env.ReplyTo
+		}
+		for _, a := range addrs {
+			if rule, ok := sup.MatchAddress(strings.ToLower(a.Address())); ok {
+				return h + " " + rule, true
+			}
+		}
+	}
+	return "", false
+}
+
+func fromAddress(env *imap.Envelope) string {
+	if len(env.From) == 0 {
+		return ""
+	}
+	return env.From[0].Address()
+}
+
+func containsSupplier(sups []*config.Supplier, sup *config.Supplier) bool {
+	for _, s := range sups {
+		if s == sup {
+			return true
+		}
+	}
+	return false
+}
+
+// returns supplier with matching filename pattern or nil
+func suitableSupplier(sups []*config.Supplier, filename string) *config.Supplier {
+	filename = strings.ToLower(filename)
+	for i := 0; i < len(sups); i++ {
+		for k := 0; k < len(sups[i].MailFileNamePattern_Prefixes); k++ {
+			if strings.HasPrefix(filename, sups[i].MailFileNamePattern_Prefixes[k]) && strings.HasSuffix(filename, sups[i].MailFileNamePattern_Suffixes[k]) {
+				return sups[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package fetch
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+func addrs(emails ...string) []*imap.Address {
+	res := make([]*imap.Address, len(emails))
+	for i, e := range emails {
+		mailbox, host, _ := strings.Cut(e, "@")
+		res[i] = &imap.Address{MailboxName: mailbox, HostName: host}
+	}
+	return res
+}
+
+func TestGetSupsByMail(t *testing.T) {
+	byemail := &config.Supplier{Name: "byemail", Email: "prices@sup.ru"}
+	bysender := &config.Supplier{Name: "bysender", Email: "prices@other.ru", MailSenders: []string{"manager@gmail.com"}}
+	bydomain := &config.Supplier{Name: "bydomain", Email: "prices@group.ru", MailDomains: []string{"group.ru"}, MailHeaders: []string{config.HeaderFrom, config.HeaderReplyTo}}
+	bysubject := &config.Supplier{Name: "bysubject", Email: "shop@market.ru", MailSubjectRegexp: "(?i)^прайс", MailHeaders: []string{config.HeaderSender}}
+	for _, s := range []*config.Supplier{byemail, bysender, bydomain, bysubject} {
+		s.MailFileNamePattern_Prefixes, s.RawCsvNamePattern_Prefix, s.NameCol = []string{"price"}, "price", []int{0}
+		if err := s.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sups := config.Suppliers{byemail, bysender, bydomain, bysubject}
+
+	tests := []struct {
+		name  string
+		env   *imap.Envelope
+		rules map[string]string // by supplier names
+	}{
+		{"email", &imap.Envelope{From: addrs("prices@sup.ru")}, map[string]string{"byemail": "from email"}},
+		{"email in upper case", &imap.Envelope{From: addrs("Prices@SUP.ru")}, map[string]string{"byemail": "from email"}},
+		{"extra sender", &imap.Envelope{From: addrs("manager@gmail.com")}, map[string]string{"bysender": "from sender manager@gmail.com"}},
+		{"subdomain", &imap.Envelope{From: addrs("robot@mail.group.ru")}, map[string]string{"bydomain": "from domain group.ru"}},
+		{"reply-to", &imap.Envelope{From: addrs("relay@mailer.com"), ReplyTo: addrs("sales@group.ru")}, map[string]string{"bydomain": "reply-to domain group.ru"}},
+		{"sender header is not checked by default", &imap.Envelope{From: addrs("relay@mailer.com"), Sender: addrs("prices@sup.ru")}, map[string]string{}},
+		{"sender and subject", &imap.Envelope{From: addrs("relay@mailer.com"), Sender: addrs("shop@market.ru"), Subject: "Прайс на сегодня"}, map[string]string{"bysubject": "sender email, subject"}},
+		{"subject is not matched", &imap.Envelope{Sender: addrs("shop@market.ru"), Subject: "Счет"}, map[string]string{}},
+		{"several suppliers", &imap.Envelope{From: addrs("prices@sup.ru", "manager@gmail.com")}, map[string]string{"byemail": "from email", "bysender": "from sender manager@gmail.com"}},
+		{"unknown", &imap.Envelope{From: addrs("spam@spam.com")}, map[string]string{}},
+		{"no addresses", &imap.Envelope{}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, rules := getSupsByMail(sups, tt.env)
+			if len(res) != len(tt.rules) {
+				t.Fatalf("matched %d suppliers, want %v", len(res), tt.rules)
+			}
+			for _, s := range res {
+				if want, ok := tt.rules[s.Name]; !ok || rules[s] != want {
+					t.Fatalf("supplier %s matched by %q, want %q", s.Name, rules[s], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSuitableSupplier(t *testing.T) {
+	a := &config.Supplier{Name: "a", MailFileNamePattern_Prefixes: []string{"price_a", ""}, MailFileNamePattern_Suffixes: []string{"", ".xlsx"}}
+	b := &config.Supplier{Name: "b", MailFileNamePattern_Prefixes: []string{"b_"}, MailFileNamePattern_Suffixes: []string{".zip"}}
+	sups := []*config.Supplier{a, b}
+	tests := []struct {
+		filename string
+		want     *config.Supplier
+	}{
+		{"price_a.csv", a},
+		{"PRICE_A.zip", a},
+		{"stock.xlsx", a},
+		{"b_stock.zip", b},
+		{"b_stock.rar", nil},
+		{"invoice.pdf", nil},
+	}
+	for _, tt := range tests {
+		if got := suitableSupplier(sups, tt.filename); got != tt.want {
+			t.Errorf("suitableSupplier(%q) = %v, want %v", tt.filename, got, tt.want)
+		}
+	}
+}
+
+// memory backend doesn't support move, so only flags are checked
+func TestAddFlags(t *testing.T) {
+	chdir(t, t.TempDir())
+	c := testClient(t)
+	downloads := t.TempDir() + "/"
+	l := logger.FromContext(context.Background())
+	seen := &config.Supplier{Name: "seen", Email: "seen@example.org", MailFileNamePattern_Prefixes: []string{"price"}, MailFileNamePattern_Suffixes: []string{".zip"}}
+	custom := &config.Supplier{Name: "custom", Email: "custom@example.org", MailFileNamePattern_Prefixes: []string{"price"}, MailFileNamePattern_Suffixes: []string{".zip"}, MailAddFlags: []string{"$Processed", imap.FlaggedFlag}}
+	sups := config.Suppliers{seen, custom}
+	acc := &config.ImapAccount{Name: "acc"}
+	cursorpath := cursorPath(acc.Name, "INBOX")
+	// cursor is created after existing message
+	if _, err := checkMailbox(context.Background(), l, c, acc, "INBOX", cursorpath, downloads, sups, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	first := c.Mailbox().UidNext
+	tests := []struct {
+		from, attachment string
+		flags            string
+	}{
+		{"seen@example.org", "price1.zip", imap.SeenFlag},
+		{"custom@example.org", "price2.zip", "$processed " + imap.FlaggedFlag}, // keywords are canonicalized by backend
+		{"seen@example.org", "invoice.pdf", ""},
+		{"other@example.org", "price3.zip", ""},
+	}
+	for _, tt := range tests {
+		if err := c.Append("INBOX", nil, time.Now(), testMessage(tt.from, tt.attachment)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := checkMailbox(context.Background(), l, c, acc, "INBOX", cursorpath, downloads, sups, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("saved %d attachments, want 2", n)
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(first, 0)
+	messages := make(chan *imap.Message)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, messages)
+	}()
+	var fetched int
+	for msg := range messages {
+		if msg.Uid < first || int(msg.Uid-first) >= len(tests) {
+			t.Errorf("fetched message with uid %d", msg.Uid)
+			continue
+		}
+		fetched++
+		var flags []string
+		for _, f := range msg.Flags {
+			if f != imap.RecentFlag {
+				flags = append(flags, f)
+			}
+		}
+		sort.Strings(flags)
+		tt := tests[msg.Uid-first]
+		want := strings.Fields(tt.flags)
+		sort.Strings(want)
+		if strings.Join(flags, " ") != strings.Join(want, " ") {
+			t.Errorf("message from %s with %s has flags %v, want %v", tt.from, tt.attachment, flags, want)
+		}
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if fetched != len(tests) {
+		t.Fatalf("fetched %d messages, want %d", fetched, len(tests))
+	}
+}
+
+// on error fetch is drained, so connection is usable, and actions of already saved messages are applied
+func TestFetchError(t *testing.T) {
+	chdir(t, t.TempDir())
+	c := testClient(t)
+	downloads := t.TempDir() + "/"
+	l := logger.FromContext(context.Background())
+	sups := config.Suppliers{{Name: "sup", Email: "sup@example.org", MailFileNamePattern_Prefixes: []string{"price"}, MailFileNamePattern_Suffixes: []string{".zip"}}}
+	acc := &config.ImapAccount{Name: "acc"}
+	cursorpath := cursorPath(acc.Name, "INBOX")
+	if _, err := checkMailbox(context.Background(), l, c, acc, "INBOX", cursorpath, downloads, sups, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	first := c.Mailbox().UidNext
+	// attachment of the second message can't be saved, as there is no such dir in downloads
+	for _, attachment := range []string{"price1.zip", "price/2.zip", "price3.zip"} {
+		if err := c.Append("INBOX", nil, time.Now(), testMessage("sup@example.org", attachment)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := checkMailbox(context.Background(), l, c, acc, "INBOX", cursorpath, downloads, sups, nil, false)
+	if err == nil || n != 1 {
+		t.Fatalf("checkMailbox() = %d, %v, want 1 saved and error", n, err)
+	}
+	cur, err := loadCursor(cursorpath)
+	if err != nil || cur == nil || cur.LastUid != first {
+		t.Fatalf("cursor %+v, %v, want last uid %d", cur, err, first)
+	}
+	// connection is not hung
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(first)
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchFlags}, messages)
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("connection is hung")
+	}
+	msg := <-messages
+	if msg == nil || !containsFlag(msg.Flags, imap.SeenFlag) {
+		t.Fatalf("saved message %v is not flagged", msg)
+	}
+}
+
+func containsFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
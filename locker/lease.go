@@ -0,0 +1,120 @@
+package locker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// exclusive lock of one or several dirs, that is renewed in background until Unlock() or loss
+type Lease struct {
+	dirpaths []string // in locking order
+	tokens   []uint64
+
+	cancel context.CancelFunc
+	lost   chan struct{}
+	done   chan struct{}
+
+	mux sync.Mutex
+	err error
+}
+
+// waits for exclusive lock like LockDirContext and starts renewing its lease every DefaultLease/3.
+// opts.Mode is ignored
+func LockDirLease(ctx context.Context, dirpath string, opts *Options) (*Lease, error) {
+	return LockDirsLease(ctx, opts, dirpath)
+}
+
+// waits for exclusive locks of all dirs like LockDirsContext and renews them together.
+// opts.Mode is ignored
+func LockDirsLease(ctx context.Context, opts *Options, dirpaths ...string) (*Lease, error) {
+	o := DefaultOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Mode = Exclusive
+	if err := LockDirsContext(ctx, &o, dirpaths...); err != nil {
+		return nil, err
+	}
+	ordered := orderDirs(dirpaths)
+	tokens := make([]uint64, len(ordered))
+	for i, dirpath := range ordered {
+		token, err := Token(dirpath)
+		if err != nil {
+			UnlockDirs(ordered...)
+			return nil, err
+		}
+		tokens[i] = token
+	}
+	renewctx, cancel := context.WithCancel(context.Background())
+	ls := &Lease{
+		dirpaths: ordered,
+		tokens:   tokens,
+		cancel:   cancel,
+		lost:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go ls.renewWorker(renewctx)
+	return ls, nil
+}
+
+func (ls *Lease) renewWorker(ctx context.Context) {
+	defer close(ls.done)
+	ticker := time.NewTicker(DefaultLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for i, dirpath := range ls.dirpaths {
+				if err := Renew(dirpath, ls.tokens[i]); err != nil {
+					ls.mux.Lock()
+					ls.err = err
+					ls.mux.Unlock()
+					close(ls.lost)
+					return
+				}
+			}
+		}
+	}
+}
+
+// fencing token of first dir in locking order, the only one for LockDirLease
+func (ls *Lease) Token() uint64 {
+	return ls.tokens[0]
+}
+
+// closed when lease renewal failed, Check() returns the reason then
+func (ls *Lease) Lost() <-chan struct{} {
+	return ls.lost
+}
+
+// returns nil if locks are still ours. Must be called before destructive steps
+func (ls *Lease) Check() error {
+	ls.mux.Lock()
+	err := ls.err
+	ls.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	for i, dirpath := range ls.dirpaths {
+		if err = Check(dirpath, ls.tokens[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stops renewal and unlocks dirs in reverse locking order. Returns the first error
+func (ls *Lease) Unlock() error {
+	ls.cancel()
+	<-ls.done
+	var firsterr error
+	for i := len(ls.dirpaths) - 1; i >= 0; i-- {
+		if err := UnlockDir(ls.dirpaths[i]); err != nil && firsterr == nil {
+			firsterr = err
+		}
+	}
+	return firsterr
+}
@@ -0,0 +1,102 @@
+package locker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// lease shorter than DefaultLease, so renewal and expiry are seen in test time
+func shortLease(t *testing.T) time.Duration {
+	prev := DefaultLease
+	DefaultLease = time.Millisecond * 150
+	t.Cleanup(func() { DefaultLease = prev })
+	return DefaultLease
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	lease := shortLease(t)
+	tests := []struct {
+		name string
+		dirs int
+	}{
+		{"one dir", 1},
+		{"several dirs", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirs := make([]string, tt.dirs)
+			for i := range dirs {
+				dirs[i] = t.TempDir()
+			}
+			ls, err := LockDirsLease(context.Background(), nil, dirs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// lock would be expired without renewal
+			time.Sleep(lease * 3)
+			if err = ls.Check(); err != nil {
+				t.Fatalf("Check() after renewals = %v", err)
+			}
+			select {
+			case <-ls.Lost():
+				t.Fatal("lease is lost")
+			default:
+			}
+			if err = ls.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range dirs {
+				if _, err = ReadLockInfo(dir); !errors.Is(err, ErrNotLocked) {
+					t.Fatalf("dir %s is left locked: %v", dir, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	lease := shortLease(t)
+	tests := []struct {
+		name string
+		take func(t *testing.T, dir string)
+	}{
+		{"lockfile replaced", func(t *testing.T, dir string) {
+			if err := os.Remove(lockfilePath(dir)); err != nil {
+				t.Fatal(err)
+			}
+			holdForeign(t, dir, &LockInfo{Pid: os.Getppid(), Hostname: self.Hostname, Time: time.Now(), Expires: time.Now().Add(time.Hour), Token: 1})
+		}},
+		{"token changed", func(t *testing.T, dir string) {
+			info, err := ReadLockInfo(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info.Token++
+			if err = os.WriteFile(lockfilePath(dir), info.encode(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ls, err := LockDirLease(context.Background(), dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ls.Unlock()
+			tt.take(t, dir)
+			select {
+			case <-ls.Lost():
+			case <-time.After(lease * 3):
+				t.Fatal("lease is not lost")
+			}
+			if err = ls.Check(); !errors.Is(err, ErrNotOwner) {
+				t.Fatalf("Check() = %v, want ErrNotOwner", err)
+			}
+		})
+	}
+}
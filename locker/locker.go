@@ -0,0 +1,472 @@
+package locker
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// lockfile is flock(2)-ed by holders and is never removed on unlock.
+// exclusive holder writes its LockInfo into it, shared holders write nothing.
+// last fencing token is kept in it between locks
+const LockfileName = ".lock"
+
+// lock of holder on this host is stale only when holder's pid is dead: lockfile is reclaimed only while
+// it is flocked, and flock is released when its holder dies, so alive pid is not a reused one.
+// Lease matters for holders on other hosts, their lock is stale when lease is expired.
+// Locks held for long by them must be taken with LockDirLease or LockDirsLease, that renew it
+var DefaultLease = time.Hour
+
+var ErrLocked = errors.New("dir is locked")
+var ErrNotOwner = errors.New("dir is locked by another process")
+var ErrNotLocked = errors.New("dir is not locked")
+
+const time_layout = time.RFC3339Nano
+
+// tries of reopening lockfile, when it was replaced by reclaimer between our open and flock
+const maxreopens = 3
+
+type Mode int
+
+const (
+	Exclusive Mode = 0
+	Shared    Mode = 1
+)
+
+func (m Mode) String() string {
+	if m == Shared {
+		return "shared"
+	}
+	return "exclusive"
+}
+
+type LockInfo struct {
+	Pid      int
+	Hostname string
+	Binary   string
+	Time     time.Time
+	Expires  time.Time
+	Token    uint64 // fencing token, grows with every exclusive lock
+}
+
+var self LockInfo
+
+func init() {
+	self.Pid = os.Getpid()
+	self.Hostname, _ = os.Hostname()
+	self.Binary = filepath.Base(os.Args[0])
+}
+
+type heldlock struct {
+	f     *os.File
+	mode  Mode
+	count int      // shared locks taken by this process
+	info  LockInfo // exclusive only
+}
+
+var held = struct {
+	sync.Mutex
+	locks map[string]*heldlock
+}{locks: make(map[string]*heldlock)}
+
+func lockfilePath(dirpath string) string {
+	return filepath.Join(dirpath, LockfileName)
+}
+
+func heldKey(dirpath string) string {
+	if abs, err := filepath.Abs(dirpath); err == nil {
+		return abs
+	}
+	return filepath.Clean(dirpath)
+}
+
+// exclusive lock, reclaims stale lock if founded
+func LockDir(dirpath string) error {
+	return LockDirMode(dirpath, Exclusive)
+}
+
+// shared lock, for readers that don't change dir's content
+func LockDirShared(dirpath string) error {
+	return LockDirMode(dirpath, Shared)
+}
+
+func LockDirMode(dirpath string, mode Mode) error {
+	key := heldKey(dirpath)
+	held.Lock()
+	defer held.Unlock()
+
+	if hl, ok := held.locks[key]; ok {
+		if mode == Shared && hl.mode == Shared {
+			hl.count++
+			return nil
+		}
+		return ErrLocked
+	}
+
+	for i := 0; i < maxreopens; i++ {
+		hl, err := tryLock(dirpath, mode)
+		if err != nil {
+			if errors.Is(err, errReopen) {
+				continue
+			}
+			return err
+		}
+		held.locks[key] = hl
+		return nil
+	}
+	return ErrLocked
+}
+
+// releases lock taken by current process
+func UnlockDir(dirpath string) error {
+	key := heldKey(dirpath)
+	held.Lock()
+	defer held.Unlock()
+
+	hl, ok := held.locks[key]
+	if !ok {
+		return ErrNotLocked
+	}
+	if hl.mode == Shared && hl.count > 1 {
+		hl.count--
+		return nil
+	}
+	delete(held.locks, key)
+	defer hl.f.Close()
+
+	if !sameFile(hl.f, lockfilePath(dirpath)) { // lockfile was reclaimed from us, so it is not ours anymore
+		return ErrNotOwner
+	}
+	if hl.mode == Exclusive {
+		if err := writeLockfile(hl.f, &LockInfo{Token: hl.info.Token}); err != nil {
+			return err
+		}
+	}
+	return syscall.Flock(int(hl.f.Fd()), syscall.LOCK_UN)
+}
+
+// returns info of exclusive holder, ErrNotLocked if there is no one
+func ReadLockInfo(dirpath string) (*LockInfo, error) {
+	info, err := readLockfile(lockfilePath(dirpath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotLocked
+		}
+		return nil, err
+	}
+	if info == nil || info.Pid == 0 {
+		return nil, ErrNotLocked
+	}
+	return info, nil
+}
+
+// returns fencing token of exclusive lock held by current process
+func Token(dirpath string) (uint64, error) {
+	held.Lock()
+	defer held.Unlock()
+	hl, ok := held.locks[heldKey(dirpath)]
+	if !ok || hl.mode != Exclusive {
+		return 0, ErrNotLocked
+	}
+	return hl.info.Token, nil
+}
+
+// checks that exclusive lock with given token is still ours: lockfile is not replaced,
+// token in it is not changed and lease is not expired. Must be called before destructive steps
+func Check(dirpath string, token uint64) error {
+	held.Lock()
+	defer held.Unlock()
+	return check(dirpath, token)
+}
+
+func check(dirpath string, token uint64) error {
+	hl, ok := held.locks[heldKey(dirpath)]
+	if !ok || hl.mode != Exclusive {
+		return ErrNotLocked
+	}
+	if hl.info.Token != token || time.Now().After(hl.info.Expires) || !sameFile(hl.f, lockfilePath(dirpath)) {
+		return ErrNotOwner
+	}
+	info, err := readLockfile(lockfilePath(dirpath))
+	if err != nil {
+		return err
+	}
+	if info == nil || !info.IsOwn() || info.Token != token {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// extends lease of exclusive lock held by current process
+func Renew(dirpath string, token uint64) error {
+	held.Lock()
+	defer held.Unlock()
+	if err := check(dirpath, token); err != nil {
+		return err
+	}
+	hl := held.locks[heldKey(dirpath)]
+	info := hl.info
+	info.Expires = time.Now().Add(DefaultLease)
+	if err := writeLockfile(hl.f, &info); err != nil {
+		return err
+	}
+	hl.info = info
+	return nil
+}
+
+var errReopen = errors.New("lockfile replaced, reopen")
+
+func tryLock(dirpath string, mode Mode) (*heldlock, error) {
+	lockpath := lockfilePath(dirpath)
+	f, err := os.OpenFile(lockpath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_EX
+	if mode == Shared {
+		how = syscall.LOCK_SH
+	}
+	if err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, err
+		}
+		info, err := readLockfile(lockpath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errReopen
+			}
+			return nil, err
+		}
+		// no info means shared holders or exclusive one that has not written info yet
+		if info == nil || info.Pid == 0 || !info.IsStale() {
+			return nil, ErrLocked
+		}
+		if err = reclaim(dirpath, info); err != nil {
+			return nil, err
+		}
+		return nil, errReopen
+	}
+
+	if !sameFile(f, lockpath) {
+		f.Close()
+		return nil, errReopen
+	}
+
+	// we hold the flock, so any holder's info in lockfile is left by crashed one
+	prev, err := readLockfile(lockpath)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var lasttoken uint64
+	if prev != nil {
+		lasttoken = prev.Token
+	}
+	hl := &heldlock{f: f, mode: mode, count: 1}
+	if mode == Exclusive {
+		hl.info = self
+		hl.info.Time = time.Now()
+		hl.info.Expires = hl.info.Time.Add(DefaultLease)
+		hl.info.Token = nextToken(lasttoken, hl.info.Time)
+		err = writeLockfile(f, &hl.info)
+	} else if prev != nil && prev.Pid != 0 {
+		err = writeLockfile(f, &LockInfo{Token: lasttoken})
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return hl, nil
+}
+
+// token is not less than lock's time in microseconds, so it keeps growing
+// even if lockfile with last token was lost on reclaim
+func nextToken(last uint64, now time.Time) uint64 {
+	if t := uint64(now.UnixMicro()); t > last {
+		return t
+	}
+	return last + 1
+}
+
+func writeLockfile(f *os.File, info *LockInfo) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(info.encode(), 0)
+	return err
+}
+
+// returns nil info on empty lockfile
+func readLockfile(path string) (*LockInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, nil
+	}
+
+	info := &LockInfo{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "pid":
+			info.Pid, _ = strconv.Atoi(val)
+		case "host":
+			info.Hostname = val
+		case "binary":
+			info.Binary = val
+		case "time":
+			info.Time, _ = time.Parse(time_layout, val)
+		case "expires":
+			info.Expires, _ = time.Parse(time_layout, val)
+		case "token":
+			info.Token, _ = strconv.ParseUint(val, 10, 64)
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	if info.Pid == 0 { // token only
+		return info, nil
+	}
+	// lockfile can be broken if holder crashed while writing it
+	if info.Time.IsZero() {
+		info.Time = fi.ModTime()
+	}
+	if info.Expires.IsZero() {
+		info.Expires = info.Time.Add(DefaultLease)
+	}
+	return info, nil
+}
+
+func (info *LockInfo) IsOwn() bool {
+	return info.Pid == self.Pid && info.Hostname == self.Hostname
+}
+
+func (info *LockInfo) IsStale() bool {
+	if info.Pid > 0 && info.Hostname == self.Hostname {
+		return !processAlive(info.Pid)
+	}
+	return time.Now().After(info.Expires)
+}
+
+func (info *LockInfo) String() string {
+	return "pid: " + strconv.Itoa(info.Pid) + ", host: " + info.Hostname + ", binary: " + info.Binary + ", since: " + info.Time.Format(time_layout) + ", token: " + strconv.FormatUint(info.Token, 10)
+}
+
+func (info *LockInfo) encode() []byte {
+	if info.Pid == 0 {
+		return []byte("token " + strconv.FormatUint(info.Token, 10) + "\n")
+	}
+	return []byte("pid " + strconv.Itoa(info.Pid) +
+		"\nhost " + info.Hostname +
+		"\nbinary " + info.Binary +
+		"\ntime " + info.Time.Format(time_layout) +
+		"\nexpires " + info.Expires.Format(time_layout) +
+		"\ntoken " + strconv.FormatUint(info.Token, 10) + "\n")
+}
+
+// moves stale lockfile aside, so its holder's flock doesn't matter anymore
+// and only one of concurrent reclaimers wins. Aside file is a dot file in locked dir,
+// it must be on the same filesystem, so dirs' scanners skip all dot files
+func reclaim(dirpath string, stale *LockInfo) error {
+	lockpath := lockfilePath(dirpath)
+	asidepath := lockpath + ".stale." + strconv.Itoa(self.Pid)
+	if err := os.Rename(lockpath, asidepath); err != nil {
+		if errors.Is(err, os.ErrNotExist) { // someone else reclaimed it
+			return nil
+		}
+		return err
+	}
+
+	// lockfile could be reclaimed and relocked by someone else between our read and rename
+	moved, err := readLockfile(asidepath)
+	if err != nil {
+		if rerr := restore(asidepath, lockpath); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	if moved == nil || moved.Pid == 0 || (!moved.sameHolder(stale) && !moved.IsStale()) {
+		if err = restore(asidepath, lockpath); err != nil {
+			return err
+		}
+		return ErrLocked
+	}
+	return os.Remove(asidepath)
+}
+
+// puts live lockfile, that was moved aside, back. Lockfile, created meanwhile, is replaced with it
+// only if nobody holds it: ones, that opened it, see that it was replaced after their flock.
+// Otherwise aside file is kept (its holder finds out, that lock is lost, on check)
+func restore(asidepath, lockpath string) error {
+	for i := 0; i < maxreopens; i++ {
+		err := os.Link(asidepath, lockpath)
+		if err == nil {
+			return os.Remove(asidepath)
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		f, err := os.OpenFile(lockpath, os.O_RDWR, 0644)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil
+			}
+			return err
+		}
+		if !sameFile(f, lockpath) {
+			f.Close()
+			continue
+		}
+		err = os.Rename(asidepath, lockpath)
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+func (info *LockInfo) sameHolder(other *LockInfo) bool {
+	return info.Pid == other.Pid && info.Hostname == other.Hostname && info.Time.Equal(other.Time)
+}
+
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,204 @@
+package locker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flocks lockfile with another fd, as other process would do, and writes info into it
+func holdForeign(t *testing.T, dirpath string, info *LockInfo) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(lockfilePath(dirpath), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if info != nil {
+		if err = writeLockfile(f, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// pid, that is not running
+func deadPid(t *testing.T) int {
+	t.Helper()
+	for pid := 4194303; pid > 4194000; pid-- {
+		if !processAlive(pid) {
+			return pid
+		}
+	}
+	t.Skip("no free pid")
+	return 0
+}
+
+func TestReclaim(t *testing.T) {
+	now := time.Now()
+	alive := os.Getppid()
+	tests := []struct {
+		name      string
+		info      *LockInfo
+		reclaimed bool
+	}{
+		{"expired lease on other host", &LockInfo{Pid: alive, Hostname: self.Hostname + "-other", Time: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour), Token: 10}, true},
+		{"expired lease of alive holder", &LockInfo{Pid: alive, Hostname: self.Hostname, Time: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour), Token: 10}, false},
+		{"dead holder", &LockInfo{Pid: deadPid(t), Hostname: self.Hostname, Time: now, Expires: now.Add(time.Hour), Token: 10}, true},
+		{"alive holder", &LockInfo{Pid: alive, Hostname: self.Hostname, Time: now, Expires: now.Add(time.Hour), Token: 10}, false},
+		{"holder on other host", &LockInfo{Pid: deadPid(t), Hostname: self.Hostname + "-other", Time: now, Expires: now.Add(time.Hour), Token: 10}, false},
+		{"shared holders", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			holdForeign(t, dir, tt.info)
+			err := LockDir(dir)
+			if !tt.reclaimed {
+				if !errors.Is(err, ErrLocked) {
+					t.Fatalf("LockDir() = %v, want ErrLocked", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LockDir() = %v, want reclaimed lock", err)
+			}
+			defer UnlockDir(dir)
+			token, err := Token(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token <= tt.info.Token {
+				t.Errorf("token %d is not greater than stale %d", token, tt.info.Token)
+			}
+			info, err := ReadLockInfo(dir)
+			if err != nil || !info.IsOwn() {
+				t.Errorf("ReadLockInfo() = %v, %v, want own info", info, err)
+			}
+			files, _ := os.ReadDir(dir)
+			for _, f := range files {
+				if f.Name() != LockfileName {
+					t.Errorf("file %s is left in dir", f.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	live := &LockInfo{Pid: os.Getppid(), Hostname: self.Hostname, Time: time.Now(), Expires: time.Now().Add(time.Hour), Token: 10}
+	tests := []struct {
+		name     string
+		prepare  func(t *testing.T, dir string)
+		restored bool
+	}{
+		{"no lockfile", func(t *testing.T, dir string) {}, true},
+		{"new lockfile is not held", func(t *testing.T, dir string) {
+			if err := os.WriteFile(lockfilePath(dir), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"new lockfile is held", func(t *testing.T, dir string) {
+			holdForeign(t, dir, &LockInfo{Pid: os.Getppid(), Hostname: self.Hostname, Time: time.Now(), Token: 11})
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			asidepath := lockfilePath(dir) + ".stale.1"
+			if err := os.WriteFile(asidepath, live.encode(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			tt.prepare(t, dir)
+			if err := restore(asidepath, lockfilePath(dir)); err != nil {
+				t.Fatal(err)
+			}
+			info, err := ReadLockInfo(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored := info != nil && info.Token == live.Token; restored != tt.restored {
+				t.Fatalf("lockfile %v, restored %v, want %v", info, restored, tt.restored)
+			}
+			// aside file is removed only when restored
+			if _, err = os.Stat(asidepath); os.IsNotExist(err) != tt.restored {
+				t.Fatalf("aside file stat err %v", err)
+			}
+		})
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	dir := t.TempDir()
+	var last uint64
+	for i := 0; i < 3; i++ {
+		if err := LockDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		token, err := Token(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token <= last {
+			t.Fatalf("token %d is not greater than previous %d", token, last)
+		}
+		last = token
+		if err = UnlockDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ReadLockInfo(dir); !errors.Is(err, ErrNotLocked) {
+			t.Fatalf("ReadLockInfo() after unlock = %v, want ErrNotLocked", err)
+		}
+	}
+	if err := UnlockDir(dir); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("second UnlockDir() = %v, want ErrNotLocked", err)
+	}
+}
+
+func TestLockInfoEncode(t *testing.T) {
+	now := time.Now().Round(0)
+	tests := []struct {
+		name string
+		info LockInfo
+	}{
+		{"holder", LockInfo{Pid: 42, Hostname: "host", Binary: "data2db", Time: now, Expires: now.Add(DefaultLease), Token: 7}},
+		{"token only", LockInfo{Token: 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), LockfileName)
+			if err := os.WriteFile(path, tt.info.encode(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readLockfile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Pid != tt.info.Pid || got.Hostname != tt.info.Hostname || got.Binary != tt.info.Binary ||
+				!got.Time.Equal(tt.info.Time) || !got.Expires.Equal(tt.info.Expires) || got.Token != tt.info.Token {
+				t.Fatalf("decoded %s, want %s", got, &tt.info)
+			}
+		})
+	}
+}
+
+func TestBrokenLockfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockfileName)
+	if err := os.WriteFile(path, []byte(strings.Join([]string{"pid 42", "host h", "tim"}, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := readLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Time.IsZero() || !info.Expires.Equal(info.Time.Add(DefaultLease)) {
+		t.Fatalf("broken lockfile's time %v and expires %v are not taken from mtime", info.Time, info.Expires)
+	}
+}
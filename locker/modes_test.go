@@ -0,0 +1,34 @@
+package locker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		first, second Mode
+		err           error
+	}{
+		{Exclusive, Exclusive, ErrLocked},
+		{Exclusive, Shared, ErrLocked},
+		{Shared, Exclusive, ErrLocked},
+		{Shared, Shared, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.first.String()+"+"+tt.second.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := LockDirMode(dir, tt.first); err != nil {
+				t.Fatal(err)
+			}
+			defer UnlockDir(dir)
+			err := LockDirMode(dir, tt.second)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("second lock = %v, want %v", err, tt.err)
+			}
+			if err == nil {
+				UnlockDir(dir)
+			}
+		})
+	}
+}
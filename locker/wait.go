@@ -0,0 +1,118 @@
+package locker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type Options struct {
+	Mode       Mode          // zero value is Exclusive
+	Timeout    time.Duration // zero means waiting until ctx is done
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultOptions = Options{
+	MinBackoff: time.Millisecond * 100,
+	MaxBackoff: time.Second * 5,
+}
+
+// waits until dir is locked, ctx is done or timeout is reached (returns ErrLocked then).
+// nil opts means DefaultOptions
+func LockDirContext(ctx context.Context, dirpath string, opts *Options) error {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	backoff := opts.MinBackoff
+	if backoff <= 0 {
+		backoff = DefaultOptions.MinBackoff
+	}
+	maxbackoff := opts.MaxBackoff
+	if maxbackoff < backoff {
+		maxbackoff = backoff
+	}
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrLocked
+			}
+			return ctx.Err()
+		case <-t.C:
+		}
+		err := LockDirMode(dirpath, opts.Mode)
+		if !errors.Is(err, ErrLocked) {
+			return err
+		}
+		// equal jitter: half of backoff plus random part of other half
+		t.Reset(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		if backoff *= 2; backoff > maxbackoff {
+			backoff = maxbackoff
+		}
+	}
+}
+
+// locks all dirs in sorted order, so processes locking the same set of dirs can't deadlock.
+// opts.Timeout is for the whole set. On error unlocks already locked ones
+func LockDirsContext(ctx context.Context, opts *Options, dirpaths ...string) error {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+	o := *opts
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+		o.Timeout = 0
+	}
+	ordered := orderDirs(dirpaths)
+	for i := 0; i < len(ordered); i++ {
+		if err := LockDirContext(ctx, ordered[i], &o); err != nil {
+			for k := i - 1; k >= 0; k-- {
+				UnlockDir(ordered[k])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// unlocks dirs in reverse locking order
+func UnlockDirs(dirpaths ...string) {
+	ordered := orderDirs(dirpaths)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		UnlockDir(ordered[i])
+	}
+}
+
+func orderDirs(dirpaths []string) []string {
+	ordered := make([]string, 0, len(dirpaths))
+loop:
+	for _, p := range dirpaths {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		} else {
+			p = filepath.Clean(p)
+		}
+		for i := 0; i < len(ordered); i++ {
+			if ordered[i] == p {
+				continue loop
+			}
+		}
+		ordered = append(ordered, p)
+	}
+	sort.Strings(ordered)
+	return ordered
+}
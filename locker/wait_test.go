@@ -0,0 +1,98 @@
+package locker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLockDirContext(t *testing.T) {
+	opts := &Options{MinBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 20}
+	tests := []struct {
+		name    string
+		release time.Duration // foreign lock is released after, zero means never
+		timeout time.Duration
+		cancel  bool
+		err     error
+	}{
+		{"free", -1, time.Second, false, nil},
+		{"released while waiting", time.Millisecond * 50, time.Second, false, nil},
+		{"timeout", 0, time.Millisecond * 100, false, ErrLocked},
+		{"canceled", 0, 0, true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.release >= 0 {
+				f := holdForeign(t, dir, nil)
+				if tt.release > 0 {
+					time.AfterFunc(tt.release, func() { f.Close() })
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(time.Millisecond*50, cancel)
+			}
+			o := *opts
+			o.Timeout = tt.timeout
+			err := LockDirContext(ctx, dir, &o)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("LockDirContext() = %v, want %v", err, tt.err)
+			}
+			if err == nil {
+				UnlockDir(dir)
+			}
+		})
+	}
+}
+
+func TestLockDirsContextTimeout(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	ordered := orderDirs(dirs)
+	// first dir is released after most of timeout and last one is never released,
+	// waiting for both must not take more than timeout for the whole set
+	opts := &Options{Timeout: time.Millisecond * 200, MinBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 20}
+	first := holdForeign(t, ordered[0], nil)
+	time.AfterFunc(opts.Timeout*3/4, func() { first.Close() })
+	holdForeign(t, ordered[len(ordered)-1], nil)
+	start := time.Now()
+	err := LockDirsContext(context.Background(), opts, dirs...)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("LockDirsContext() = %v, want ErrLocked", err)
+	}
+	if d := time.Since(start); d > opts.Timeout*3/2 {
+		t.Fatalf("LockDirsContext() waited %v, timeout is %v", d, opts.Timeout)
+	}
+	// already locked dirs are unlocked on error
+	for _, dir := range ordered[:len(ordered)-1] {
+		if err = LockDir(dir); err != nil {
+			t.Fatalf("dir %s is left locked: %v", dir, err)
+		}
+		UnlockDir(dir)
+	}
+}
+
+func TestOrderDirs(t *testing.T) {
+	base := t.TempDir()
+	a, b := filepath.Join(base, "a"), filepath.Join(base, "b")
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"sorted", []string{b, a}, []string{a, b}},
+		{"duplicates", []string{a, b, a + "/", b}, []string{a, b}},
+		{"unclean", []string{filepath.Join(b, "..", "a")}, []string{a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderDirs(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("orderDirs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
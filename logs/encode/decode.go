@@ -0,0 +1,104 @@
+package encode
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrShortLog = errors.New("log is shorter than its header")
+var ErrBadTagsLength = errors.New("log's tags length is out of range")
+var ErrBadTags = errors.New("log's tags are malformed")
+
+type Record struct {
+	Type    LogType   `json:"type"`
+	Time    time.Time `json:"time"`
+	Tags    []string  `json:"tags"` // without name
+	Name    string    `json:"name"`
+	Message string    `json:"message"`
+	Fields  Fields    `json:"fields,omitempty"`
+}
+
+func Decode(log []byte) (Record, error) {
+	var rec Record
+	if len(log) < 11 {
+		return rec, ErrShortLog
+	}
+	tagslen := int(byteOrder.Uint16(log[9:11]))
+	if tagslen < 11 || tagslen > len(log) {
+		return rec, ErrBadTagsLength
+	}
+	tags, err := decodeTags(log[11:tagslen], log[0]&EscapedTagsFlag != 0)
+	if err != nil {
+		return rec, err
+	}
+	rec.Type = LogType(log[0] & LogTypeMask)
+	rec.Time = time.UnixMicro(int64(byteOrder.Uint64(log[1:9])))
+	if len(tags) > 0 {
+		rec.Tags, rec.Name = tags[:len(tags)-1], tags[len(tags)-1]
+	} else {
+		rec.Tags = tags
+	}
+	if log[0]&FieldsFlag != 0 {
+		fields, l, err := decodeFields(log[tagslen:])
+		if err != nil {
+			return rec, err
+		}
+		rec.Fields, tagslen = fields, tagslen+l
+	}
+	rec.Message = string(log[tagslen:])
+	return rec, nil
+}
+
+// tags are "[tag] " sequences, if escaped, TagEndSep and TagEscape in tags are escaped with TagEscape
+func decodeTags(b []byte, escaped bool) ([]string, error) {
+	tags := make([]string, 0, 4)
+	for len(b) > 0 {
+		if b[0] != TagStartSep {
+			return nil, ErrBadTags
+		}
+		tag := make([]byte, 0, 16)
+		end := 1
+		for ; end < len(b); end++ {
+			if b[end] == TagEndSep && (escaped || end+1 == len(b) || b[end+1] == TagDelim) {
+				break
+			}
+			if escaped && b[end] == TagEscape && end+1 < len(b) {
+				end++
+			}
+			tag = append(tag, b[end])
+		}
+		if end+1 >= len(b) || b[end+1] != TagDelim {
+			return nil, ErrBadTags
+		}
+		tags = append(tags, string(tag))
+		b = b[end+2:]
+	}
+	return tags, nil
+}
+
+func GetLogTime(log []byte) time.Time {
+	return time.UnixMicro(int64(byteOrder.Uint64(log[1:9])))
+}
+
+func (lt LogType) MarshalText() ([]byte, error) {
+	return lt.ByteStr(), nil
+}
+
+func (lt *LogType) UnmarshalText(b []byte) error {
+	t, ok := ParseLogType(string(b))
+	if !ok {
+		return errors.New("unknown log type: " + string(b))
+	}
+	*lt = t
+	return nil
+}
+
+// accepts DBG/INF/WRN/ERR
+func ParseLogType(s string) (LogType, bool) {
+	for _, lt := range []LogType{Debug, Info, Warning, Error} {
+		if lt.String() == s {
+			return lt, true
+		}
+	}
+	return 0, false
+}
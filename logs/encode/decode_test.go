@@ -0,0 +1,182 @@
+package encode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	now := time.UnixMicro(time.Now().UnixMicro())
+	tests := []struct {
+		name    string
+		tags    []string
+		logname string
+		msg     string
+		fields  []Field
+	}{
+		{"plain", []string{"emailer", "fetch"}, "checkMail", "saved", nil},
+		{"no tags", nil, "main", "started", nil},
+		{"empty name", []string{"a"}, "", "msg", nil},
+		{"empty message", []string{"a"}, "n", "", nil},
+		{"separators in tags", []string{"a] b", `c\`, "[d]", `\] `}, "n] x", "msg] [y", nil},
+		{"fields", []string{"a"}, "n", "msg", Fields{{Key: "file", Kind: StringField, Str: "p.csv"}, {Key: "rows", Kind: IntField, Int: 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tags []byte
+			if len(tt.tags) > 0 {
+				tags = AppendTags(nil, tt.tags...)
+			}
+			rec, err := Decode(EncodeLog(Warning, now, tags, tt.logname, tt.msg, tt.fields...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wanttags := tt.tags
+			if wanttags == nil {
+				wanttags = []string{}
+			}
+			if rec.Type != Warning || !rec.Time.Equal(now) || !reflect.DeepEqual(rec.Tags, wanttags) || rec.Name != tt.logname || rec.Message != tt.msg {
+				t.Fatalf("decoded %+v", rec)
+			}
+			if len(tt.fields) > 0 && !reflect.DeepEqual(rec.Fields, Fields(tt.fields)) {
+				t.Fatalf("decoded fields %v, want %v", rec.Fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	good := EncodeLog(Info, time.Now(), AppendTags(nil, "a"), "n", "msg")
+	corrupt := func(f func(log []byte)) []byte {
+		log := append([]byte{}, good...)
+		f(log)
+		return log
+	}
+	tests := []struct {
+		name string
+		log  []byte
+		err  error
+	}{
+		{"short", good[:10], ErrShortLog},
+		{"tags length below header", corrupt(func(log []byte) { byteOrder.PutUint16(log[9:11], 5) }), ErrBadTagsLength},
+		{"tags length above log", corrupt(func(log []byte) { byteOrder.PutUint16(log[9:11], uint16(len(log)+1)) }), ErrBadTagsLength},
+		{"tag without start", corrupt(func(log []byte) { log[11] = 'x' }), ErrBadTags},
+		{"unterminated tag", corrupt(func(log []byte) { byteOrder.PutUint16(log[9:11], uint16(len(log)-4)) }), ErrBadTags},
+		{"fields flag without fields", corrupt(func(log []byte) { log[0] |= FieldsFlag }), ErrBadFields},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.log); !errors.Is(err, tt.err) {
+				t.Fatalf("Decode() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFrames(t *testing.T) {
+	logs := [][]byte{
+		EncodeLog(Info, time.Now(), nil, "a", "first"),
+		EncodeLog(Error, time.Now(), AppendTags(nil, "t"), "b", "second", Field{Key: "k", Kind: IntField, Int: 1}),
+	}
+	var stream []byte
+	for _, log := range logs {
+		stream = AppendFrame(stream, log)
+	}
+	tests := []struct {
+		name   string
+		stream []byte
+		frames int
+		err    error
+	}{
+		{"whole", stream, 2, io.EOF},
+		{"empty", nil, 0, io.EOF},
+		{"truncated header", stream[:len(stream)-len(logs[1])-2], 1, io.ErrUnexpectedEOF},
+		{"truncated log", stream[:len(stream)-1], 1, io.ErrUnexpectedEOF},
+		{"bad length", append(AppendFrame(nil, logs[0]), 1, 0, 0, 0), 1, ErrBadFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.stream)
+			for i := 0; i < tt.frames; i++ {
+				log, err := ReadFrame(r)
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(log, logs[i]) {
+					t.Fatalf("frame %d differs", i)
+				}
+			}
+			if _, err := ReadFrame(r); !errors.Is(err, tt.err) {
+				t.Fatalf("ReadFrame() at end = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeToString(t *testing.T) {
+	logtime := time.Date(2024, 5, 1, 10, 20, 30, 123000, time.Local)
+	good := EncodeLog(Warning, logtime, AppendTags(nil, "emailer"), "fetch", "saved", Field{Key: "n", Kind: IntField, Int: 2})
+	oversized := append([]byte{}, good...)
+	byteOrder.PutUint16(oversized[9:11], uint16(len(oversized)+10))
+	tests := []struct {
+		name string
+		log  []byte
+		want string
+	}{
+		{"good", good, "[WRN] [05/01 10:20:30.000123] [emailer] [fetch] saved n=2"},
+		{"empty", nil, "[UNK] corrupt record: " + ErrShortLog.Error()},
+		{"truncated", good[:7], "[WRN] corrupt record: " + ErrShortLog.Error()},
+		{"oversized tags length", oversized, "[WRN] corrupt record: " + ErrBadTagsLength.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeToString(tt.log); got != tt.want {
+				t.Fatalf("DecodeToString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if lvl := GetLogLvl(nil); lvl != ZeroLevel {
+		t.Fatalf("GetLogLvl(nil) = %d, want ZeroLevel", lvl)
+	}
+}
+
+func TestDecodeTagsVersions(t *testing.T) {
+	logtime := time.Date(2024, 5, 1, 10, 20, 30, 0, time.Local)
+	// logs encoded before tags escaping, without EscapedTagsFlag
+	legacy := func(tags, msg string) []byte {
+		log := make([]byte, 11, 11+len(tags)+len(msg))
+		log[0] = Info.Byte()
+		byteOrder.PutUint64(log[1:], uint64(logtime.UnixMicro()))
+		byteOrder.PutUint16(log[9:], uint16(11+len(tags)))
+		log = append(log, tags...)
+		return append(log, msg...)
+	}
+	tests := []struct {
+		name string
+		log  []byte
+		tags []string
+		str  string
+	}{
+		{"escaped", EncodeLog(Info, logtime, AppendTags(nil, `c:\dir`, "a]b"), "n", "msg"), []string{`c:\dir`, "a]b"}, `[INF] [05/01 10:20:30] [c:\dir] [a]b] [n] msg`},
+		{"legacy", legacy(`[c:\dir] [a]b] [n] `, "msg"), []string{`c:\dir`, "a]b"}, `[INF] [05/01 10:20:30] [c:\dir] [a]b] [n] msg`},
+		{"legacy escape-like", legacy(`[a\] [b\\] [n] `, "msg"), []string{`a\`, `b\\`}, `[INF] [05/01 10:20:30] [a\] [b\\] [n] msg`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := Decode(tt.log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Type != Info || !reflect.DeepEqual(rec.Tags, tt.tags) || rec.Name != "n" || rec.Message != "msg" {
+				t.Fatalf("decoded %+v", rec)
+			}
+			if got := DecodeToString(tt.log); got != tt.str {
+				t.Fatalf("DecodeToString() = %q, want %q", got, tt.str)
+			}
+		})
+	}
+}
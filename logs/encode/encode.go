@@ -11,16 +11,29 @@ const (
 	TagStartSep byte = 91 // "["
 	TagEndSep   byte = 93 // "]"
 	TagDelim    byte = 32 // " "
+	TagEscape   byte = 92 // "\", escapes TagEndSep and itself inside tags
 )
 
+// logs with escaped tags have EscapedTagsFlag set in their type byte,
+// tags of logs without it are not escaped and end on first "] "
+const EscapedTagsFlag byte = 0x40
+
 const TagsMaxLength = 65535
 const time_layout = " [01/02 15:04:05.999999] "
 
 var byteOrder binary.ByteOrder = binary.LittleEndian
 
-func EncodeLog(logtype LogType, logtime time.Time, tags []byte, name, logstr string) []byte {
-	log := encode(tags, logstr, name)
-	log[0] = logtype.Byte()
+func EncodeLog(logtype LogType, logtime time.Time, tags []byte, name, logstr string, fields ...Field) []byte {
+	var log []byte
+	if len(fields) == 0 {
+		log = encode(tags, logstr, true, name) // name is always the last tag, even empty
+		log[0] = logtype.Byte() | EscapedTagsFlag
+	} else {
+		log = encode(tags, "", true, name)
+		log = appendFields(log, fields)
+		log = append(log, logstr...)
+		log[0] = logtype.Byte() | EscapedTagsFlag | FieldsFlag
+	}
 	if !logtime.IsZero() {
 		byteOrder.PutUint64(log[1:], uint64(logtime.UnixMicro()))
 	}
@@ -29,12 +42,12 @@ func EncodeLog(logtype LogType, logtime time.Time, tags []byte, name, logstr str
 
 func AppendTags(tags []byte, newtags ...string) []byte {
 	if len(tags) == 0 {
-		return encode(make([]byte, 11), "", newtags...)
+		return encode(make([]byte, 11), "", false, newtags...)
 	}
-	return encode(tags, "", newtags...)
+	return encode(tags, "", false, newtags...)
 }
 
-func encode(tags []byte, logstr string, newtags ...string) []byte {
+func encode(tags []byte, logstr string, keepempty bool, newtags ...string) []byte {
 	var tgs []byte
 	if len(tags) == 0 {
 		tgs = make([]byte, 11)
@@ -44,13 +57,11 @@ func encode(tags []byte, logstr string, newtags ...string) []byte {
 	tagslen := len(tgs)
 	tslist := make([][]byte, 0, len(newtags))
 	for _, tg := range newtags {
-		if len(tg) > 0 {
-			tb := make([]byte, len(tg)+3)
-
-			n := copy(tb[1:], tg)
+		if len(tg) > 0 || keepempty {
+			tb := make([]byte, 1, len(tg)+3)
 			tb[0] = TagStartSep
-			tb[n+1] = TagEndSep
-			tb[n+2] = TagDelim
+			tb = appendEscapedTag(tb, tg)
+			tb = append(tb, TagEndSep, TagDelim)
 
 			tagslen += len(tb)
 			tslist = append(tslist, tb)
@@ -69,17 +80,53 @@ func encode(tags []byte, logstr string, newtags ...string) []byte {
 	return log
 }
 
+// tag may contain "] ", so TagEndSep and TagEscape are escaped
+func appendEscapedTag(b []byte, tag string) []byte {
+	for i := 0; i < len(tag); i++ {
+		if tag[i] == TagEndSep || tag[i] == TagEscape {
+			b = append(b, TagEscape)
+		}
+		b = append(b, tag[i])
+	}
+	return b
+}
+
+// corrupt log is rendered as "[UNK] corrupt record: <error>" line
 func DecodeToString(log []byte) string {
-	if len(log) < 11 {
-		panic("logs/encode/DecodeToString() recieved log with len less than 11") // TODO:?
+	rec, err := Decode(log)
+	if err != nil {
+		return suckutils.Concat(string(TagStartSep), LogType(GetLogLvl(log)).String(), string(TagEndSep), " corrupt record: ", err.Error())
+	}
+	return rec.String()
+}
+
+func (rec Record) String() string {
+	b := make([]byte, 0, 64+len(rec.Message))
+	b = append(b, TagStartSep)
+	b = append(b, rec.Type.String()...)
+	b = append(b, TagEndSep)
+	b = rec.Time.AppendFormat(b, time_layout)
+	for _, tg := range rec.Tags {
+		b = append(b, TagStartSep)
+		b = append(b, tg...)
+		b = append(b, TagEndSep, TagDelim)
 	}
-	return suckutils.Concat(string(TagStartSep), LogType(log[0]).String(), string(TagEndSep), time.UnixMicro(int64(byteOrder.Uint64(log[1:9]))).Format(time_layout), string(log[11:]))
+	b = append(b, TagStartSep)
+	b = append(b, rec.Name...)
+	b = append(b, TagEndSep, TagDelim)
+	b = append(b, rec.Message...)
+	b = append(b, rec.Fields.String()...)
+	return string(b)
 }
 
 func PrintLog(log []byte) {
-	println(LogType(log[0]).Colorize(), DecodeToString(log))
+	println(LogType(GetLogLvl(log)).Colorize(), DecodeToString(log))
 }
 
+// ZeroLevel for empty log
 func GetLogLvl(log []byte) LogsFlushLevel {
-	return LogsFlushLevel(log[0])
+	if len(log) == 0 {
+		return ZeroLevel
+	}
+	return LogsFlushLevel(log[0] & LogTypeMask)
 }
@@ -0,0 +1,214 @@
+package encode
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// log with fields has FieldsFlag set in its type byte, and the fields section between tags and message:
+// version byte, uint16 section length (without version and length), fields.
+// field is: kind byte, uint8 key length, key, value (uint16 length + bytes for strings, 8 bytes for numbers).
+// logs without fields are encoded as before fields were introduced
+const FieldsFlag byte = 0x80
+const LogTypeMask byte = 0x3f
+
+const FieldsVersion byte = 1
+const FieldsMaxLength = 65535
+
+var ErrBadFields = errors.New("log's fields are malformed")
+
+type FieldKind byte
+
+const (
+	StringField   FieldKind = 1
+	IntField      FieldKind = 2
+	FloatField    FieldKind = 3
+	ErrorField    FieldKind = 4
+	DurationField FieldKind = 5
+)
+
+type Field struct {
+	Key   string
+	Kind  FieldKind
+	Str   string // StringField, ErrorField
+	Int   int64  // IntField, DurationField (nanoseconds)
+	Float float64
+}
+
+type Fields []Field
+
+func (f Field) Value() interface{} {
+	switch f.Kind {
+	case StringField, ErrorField:
+		return f.Str
+	case IntField:
+		return f.Int
+	case FloatField:
+		return f.Float
+	case DurationField:
+		return time.Duration(f.Int)
+	}
+	return nil
+}
+
+func (f Field) ValueString() string {
+	switch f.Kind {
+	case StringField, ErrorField:
+		return f.Str
+	case IntField:
+		return strconv.FormatInt(f.Int, 10)
+	case FloatField:
+		return strconv.FormatFloat(f.Float, 'f', -1, 64)
+	case DurationField:
+		return time.Duration(f.Int).String()
+	}
+	return ""
+}
+
+// " key=value key2=value2", strings are quoted
+func (fs Fields) String() string {
+	var s string
+	for _, f := range fs {
+		if f.Kind == StringField || f.Kind == ErrorField {
+			s += " " + f.Key + "=" + strconv.Quote(f.Str)
+		} else {
+			s += " " + f.Key + "=" + f.ValueString()
+		}
+	}
+	return s
+}
+
+// object with fields in their order, durations are strings
+func (fs Fields) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, f := range fs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		k, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, k...)
+		b = append(b, ':')
+		var v []byte
+		switch f.Kind {
+		case FloatField:
+			if math.IsNaN(f.Float) || math.IsInf(f.Float, 0) {
+				v, err = json.Marshal(f.ValueString())
+			} else {
+				v, err = json.Marshal(f.Float)
+			}
+		case IntField:
+			v, err = json.Marshal(f.Int)
+		default:
+			v, err = json.Marshal(f.ValueString())
+		}
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, v...)
+	}
+	return append(b, '}'), nil
+}
+
+// fields, that don't fit into FieldsMaxLength, are dropped, last fitting string is truncated.
+// Keys and strings are cut on rune boundaries
+func appendFields(b []byte, fields []Field) []byte {
+	b = append(b, FieldsVersion, 0, 0)
+	start := len(b)
+	for _, f := range fields {
+		key := cutString(f.Key, 255)
+		free := FieldsMaxLength - (len(b) - start) - 2 - len(key)
+		switch f.Kind {
+		case IntField, DurationField, FloatField:
+			if free < 8 {
+				continue
+			}
+		default:
+			if free < 2 {
+				continue
+			}
+		}
+		b = append(b, byte(f.Kind), byte(len(key)))
+		b = append(b, key...)
+		switch f.Kind {
+		case IntField, DurationField:
+			b = appendUint64(b, uint64(f.Int))
+		case FloatField:
+			b = appendUint64(b, math.Float64bits(f.Float))
+		default:
+			s := cutString(f.Str, free-2)
+			b = append(b, 0, 0)
+			byteOrder.PutUint16(b[len(b)-2:], uint16(len(s)))
+			b = append(b, s...)
+		}
+	}
+	byteOrder.PutUint16(b[start-2:], uint16(len(b)-start))
+	return b
+}
+
+// cuts s to at most n bytes, not splitting runes
+func cutString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// returns fields and length of the whole section. Fields of unknown version are skipped
+func decodeFields(b []byte) (Fields, int, error) {
+	if len(b) < 3 {
+		return nil, 0, ErrBadFields
+	}
+	version, l := b[0], int(byteOrder.Uint16(b[1:3]))
+	if 3+l > len(b) {
+		return nil, 0, ErrBadFields
+	}
+	if version != FieldsVersion {
+		return nil, 3 + l, nil
+	}
+	fields := make(Fields, 0, 4)
+	for p := b[3 : 3+l]; len(p) > 0; {
+		if len(p) < 2 || len(p) < 2+int(p[1]) {
+			return nil, 0, ErrBadFields
+		}
+		f := Field{Kind: FieldKind(p[0]), Key: string(p[2 : 2+int(p[1])])}
+		p = p[2+int(p[1]):]
+		switch f.Kind {
+		case IntField, DurationField, FloatField:
+			if len(p) < 8 {
+				return nil, 0, ErrBadFields
+			}
+			if f.Kind == FloatField {
+				f.Float = math.Float64frombits(byteOrder.Uint64(p))
+			} else {
+				f.Int = int64(byteOrder.Uint64(p))
+			}
+			p = p[8:]
+		case StringField, ErrorField:
+			if len(p) < 2 || len(p) < 2+int(byteOrder.Uint16(p)) {
+				return nil, 0, ErrBadFields
+			}
+			f.Str = string(p[2 : 2+int(byteOrder.Uint16(p))])
+			p = p[2+int(byteOrder.Uint16(p)):]
+		default:
+			return nil, 0, ErrBadFields
+		}
+		fields = append(fields, f)
+	}
+	return fields, 3 + l, nil
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	byteOrder.PutUint64(b[len(b)-8:], v)
+	return b
+}
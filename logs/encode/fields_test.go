@@ -0,0 +1,87 @@
+package encode
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestFieldsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+	}{
+		{"string", Fields{{Key: "file", Kind: StringField, Str: "прайс.csv"}}},
+		{"empty string and key", Fields{{Key: "", Kind: StringField, Str: ""}}},
+		{"numbers", Fields{{Key: "i", Kind: IntField, Int: -5}, {Key: "f", Kind: FloatField, Float: 1.5}, {Key: "d", Kind: DurationField, Int: int64(time.Second)}}},
+		{"infinity", Fields{{Key: "f", Kind: FloatField, Float: math.Inf(1)}}},
+		{"error", Fields{{Key: "err", Kind: ErrorField, Str: "boom"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := appendFields(nil, tt.fields)
+			got, l, err := decodeFields(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l != len(b) {
+				t.Fatalf("section length %d, want %d", l, len(b))
+			}
+			if !reflect.DeepEqual(got, tt.fields) {
+				t.Fatalf("decoded %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestFieldsOverflow(t *testing.T) {
+	long := strings.Repeat("я", FieldsMaxLength) // two bytes runes
+	tests := []struct {
+		name   string
+		fields Fields
+		keys   []string // of kept fields
+	}{
+		{"long string is truncated", Fields{{Key: "s", Kind: StringField, Str: long}}, []string{"s"}},
+		{"fields after full section are dropped", Fields{{Key: "s", Kind: StringField, Str: long}, {Key: "i", Kind: IntField, Int: 1}, {Key: "e", Kind: ErrorField, Str: "x"}}, []string{"s"}},
+		{"fields before long string are kept", Fields{{Key: "i", Kind: IntField, Int: 1}, {Key: "s", Kind: StringField, Str: long}}, []string{"i", "s"}},
+		{"long key is truncated", Fields{{Key: strings.Repeat("ключ", 100), Kind: IntField, Int: 1}}, []string{strings.Repeat("ключ", 100)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := appendFields(nil, tt.fields)
+			got, l, err := decodeFields(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l != len(b) || l-3 > FieldsMaxLength {
+				t.Fatalf("section length %d, encoded %d", l, len(b))
+			}
+			if len(got) != len(tt.keys) {
+				t.Fatalf("decoded %d fields, want %d", len(got), len(tt.keys))
+			}
+			for i, f := range got {
+				if !utf8.ValidString(f.Key) || !utf8.ValidString(f.Str) {
+					t.Fatalf("field %d is cut inside rune", i)
+				}
+				if len(f.Key) > 255 || !strings.HasPrefix(tt.keys[i], f.Key) {
+					t.Fatalf("field %d key %q, want prefix of %q", i, f.Key, tt.keys[i])
+				}
+				if !strings.HasPrefix(tt.fields[i].Str, f.Str) {
+					t.Fatalf("field %d value is not prefix of original", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldsUnknownVersion(t *testing.T) {
+	b := appendFields(nil, Fields{{Key: "i", Kind: IntField, Int: 1}})
+	b[0] = FieldsVersion + 1
+	fields, l, err := decodeFields(append(b, "msg"...))
+	if err != nil || fields != nil || l != len(b) {
+		t.Fatalf("decodeFields() = %v, %d, %v, want skipped section of %d bytes", fields, l, err, len(b))
+	}
+}
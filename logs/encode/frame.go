@@ -0,0 +1,44 @@
+package encode
+
+import (
+	"errors"
+	"io"
+)
+
+// logs are streamed (to logsserver, in logs files) as frames: uint32 length + encoded log
+const FrameHeaderLength = 4
+const FrameMaxLength = 1 << 20
+
+var ErrBadFrame = errors.New("bad log frame")
+
+func AppendFrame(buf []byte, log []byte) []byte {
+	var head [FrameHeaderLength]byte
+	byteOrder.PutUint32(head[:], uint32(len(log)))
+	buf = append(buf, head[:]...)
+	return append(buf, log...)
+}
+
+func WriteFrame(w io.Writer, log []byte) error {
+	_, err := w.Write(AppendFrame(make([]byte, 0, FrameHeaderLength+len(log)), log))
+	return err
+}
+
+// returns io.EOF only on clean end of stream, io.ErrUnexpectedEOF on truncated frame
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var head [FrameHeaderLength]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	l := byteOrder.Uint32(head[:])
+	if l < 11 || l > FrameMaxLength {
+		return nil, ErrBadFrame
+	}
+	log := make([]byte, l)
+	if _, err := io.ReadFull(r, log); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return log, nil
+}
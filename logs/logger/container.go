@@ -1,43 +1,57 @@
 package logger
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/okonma-violet/spec/logs/encode"
 )
 
 type LogsContainer struct {
-	ch   chan [][]byte
+	f    *Flusher
 	tags []byte //tags
+	lvl  atomic.Pointer[cachedlevel]
 }
 
 func (f *Flusher) NewLogsContainer(tags ...string) Logger {
 	tagslist := encode.AppendTags(nil, tags...)
-	return &LogsContainer{ch: f.ch, tags: tagslist}
+	return &LogsContainer{f: f, tags: tagslist}
 }
 
-func (l *LogsContainer) Debug(name, logstr string) {
-	l.ch <- [][]byte{encode.EncodeLog(encode.Debug, time.Now(), l.tags, name, logstr)}
+func (l *LogsContainer) Debug(name, logstr string, fields ...Field) {
+	if encode.DebugLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
+	l.f.push([][]byte{encode.EncodeLog(encode.Debug, time.Now(), l.tags, name, logstr, fields...)})
 }
 
-func (l *LogsContainer) Info(name, logstr string) {
-	l.ch <- [][]byte{encode.EncodeLog(encode.Info, time.Now(), l.tags, name, logstr)}
+func (l *LogsContainer) Info(name, logstr string, fields ...Field) {
+	if encode.InfoLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
+	l.f.push([][]byte{encode.EncodeLog(encode.Info, time.Now(), l.tags, name, logstr, fields...)})
 }
 
-func (l *LogsContainer) Warning(name, logstr string) {
-	l.ch <- [][]byte{encode.EncodeLog(encode.Warning, time.Now(), l.tags, name, logstr)}
+func (l *LogsContainer) Warning(name, logstr string, fields ...Field) {
+	if encode.WarningLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
+	l.f.push([][]byte{encode.EncodeLog(encode.Warning, time.Now(), l.tags, name, logstr, fields...)})
 }
 
-func (l *LogsContainer) Error(name string, logerr error) {
+func (l *LogsContainer) Error(name string, logerr error, fields ...Field) {
+	if encode.ErrorLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
 	var logstr string
 	if logerr != nil {
 		logstr = logerr.Error()
 	} else {
 		logstr = "nil err"
 	}
-	l.ch <- [][]byte{encode.EncodeLog(encode.Error, time.Now(), l.tags, name, logstr)}
+	l.f.push([][]byte{encode.EncodeLog(encode.Error, time.Now(), l.tags, name, logstr, fields...)})
 }
 
 func (l *LogsContainer) NewSubLogger(tags ...string) Logger {
-	return &LogsContainer{ch: l.ch, tags: encode.AppendTags(l.tags, tags...)}
+	return &LogsContainer{f: l.f, tags: encode.AppendTags(l.tags, tags...)}
 }
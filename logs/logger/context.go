@@ -0,0 +1,46 @@
+package logger
+
+import "context"
+
+type ctxkey struct{}
+
+func WithContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, ctxkey{}, l)
+}
+
+// returns logger from ctx, or logger that drops all logs, if there is none
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(ctxkey{}).(Logger); ok {
+		return l
+	}
+	return nopLogger{}
+}
+
+// for per-operation tags, e.g. WithTags(ctx, Tag("upload", id), Tag("file", name)).
+// returns ctx with sublogger of ctx's logger and the sublogger itself
+func WithTags(ctx context.Context, tags ...string) (context.Context, Logger) {
+	l := FromContext(ctx).NewSubLogger(tags...)
+	return WithContext(ctx, l), l
+}
+
+// "key=value"
+func Tag(key, value string) string {
+	return key + "=" + value
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(name, logstr string, fields ...Field)       {}
+func (nopLogger) Info(name, logstr string, fields ...Field)        {}
+func (nopLogger) Warning(name, logstr string, fields ...Field)     {}
+func (nopLogger) Error(name string, logerr error, fields ...Field) {}
+func (nopLogger) Flush()                                           {}
+func (nopLogger) Close()                                           {}
+
+func (l nopLogger) NewSubLogger(tags ...string) Logger {
+	return l
+}
+
+func (l nopLogger) NewPackageSubLogger(logsBufLen int, tags ...string) PackageLogger {
+	return l
+}
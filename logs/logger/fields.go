@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+type Field = encode.Field
+
+func String(key, val string) Field {
+	return Field{Key: key, Kind: encode.StringField, Str: val}
+}
+
+func Int(key string, val int) Field {
+	return Field{Key: key, Kind: encode.IntField, Int: int64(val)}
+}
+
+func Int64(key string, val int64) Field {
+	return Field{Key: key, Kind: encode.IntField, Int: val}
+}
+
+func Float(key string, val float64) Field {
+	return Field{Key: key, Kind: encode.FloatField, Float: val}
+}
+
+func Err(key string, err error) Field {
+	if err == nil {
+		return Field{Key: key, Kind: encode.ErrorField, Str: "nil err"}
+	}
+	return Field{Key: key, Kind: encode.ErrorField, Str: err.Error()}
+}
+
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, Kind: encode.DurationField, Int: int64(val)}
+}
@@ -1,57 +1,270 @@
 package logger
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/okonma-violet/spec/logs/encode"
 )
 
 type Flusher struct {
-	ch         chan [][]byte
-	flushlvl   encode.LogsFlushLevel
+	sinks      []LeveledSink
 	cancel     chan struct{}
 	allflushed chan struct{}
+
+	opts     FlusherOptions
+	mux      sync.Mutex
+	notfull  *sync.Cond
+	queue    [][]byte
+	notify   chan struct{}
+	closed   bool
+	reported bool // last drops report is made, later drops are printed
+	sampled  int
+	dropped  [encode.ErrorLevel + 1]int
+
+	levels   atomic.Pointer[levelsconf]
+	deflevel encode.LogsFlushLevel // of options, levels file's default overrides it
 }
 
-var flushertags []byte
+var flushertags = encode.AppendTags(nil, "logger", "Flusher")
 
 const chanlen = 4
 
-// TODO: make logsserver
-// when nonlocal = true, flush logs to logsservers, when logsserver is not available, saves logs for further flush to this server on reconnect
-func NewFlusher(logsflushlvl encode.LogsFlushLevel /*, nonlocal bool*/) LogsFlusher {
+// what to do with new log, when flusher's queue is full
+type OverflowPolicy byte
+
+const (
+	// waits for free space in queue
+	Block OverflowPolicy = 0
+	// drops the log with lowest level, new log or already queued one
+	DropLowest OverflowPolicy = 1
+	// keeps every SampleRate-th debug or info log and every warning and error, dropping lowest levels for them
+	Sample OverflowPolicy = 2
+)
+
+type FlusherOptions struct {
+	ConsoleLevel encode.LogsFlushLevel // level of colorized stderr output, ZeroLevel disables it
+	Level        encode.LogsFlushLevel // logs below it are not encoded by loggers, default is the lowest of sinks' levels
+	QueueLength  int                   // logs, default is chanlen
+	Overflow     OverflowPolicy
+	SampleRate   int           // for Sample policy, default is 10
+	DropsReport  time.Duration // interval of warnings about dropped logs, default is 10 seconds
+}
+
+// logsflushlvl is the level of colorized stderr output, ZeroLevel disables it.
+// sinks are closed by flusher after last flush. For flushing to logsserver use RemoteSink
+func NewFlusher(logsflushlvl encode.LogsFlushLevel, sinks ...LeveledSink) LogsFlusher {
+	return NewFlusherWithOptions(FlusherOptions{ConsoleLevel: logsflushlvl}, sinks...)
+}
+
+func NewFlusherWithOptions(opts FlusherOptions, sinks ...LeveledSink) LogsFlusher {
+	if opts.QueueLength <= 0 {
+		opts.QueueLength = chanlen
+	}
+	if opts.SampleRate <= 0 {
+		opts.SampleRate = 10
+	}
+	if opts.DropsReport <= 0 {
+		opts.DropsReport = time.Second * 10
+	}
 	f := &Flusher{
-		ch:         make(chan [][]byte, chanlen),
-		flushlvl:   logsflushlvl,
+		sinks:      make([]LeveledSink, 0, len(sinks)+1),
 		cancel:     make(chan struct{}),
 		allflushed: make(chan struct{}),
+		opts:       opts,
+		queue:      make([][]byte, 0, opts.QueueLength),
+		notify:     make(chan struct{}, 1),
+	}
+	f.notfull = sync.NewCond(&f.mux)
+	if opts.ConsoleLevel != encode.ZeroLevel {
+		f.sinks = append(f.sinks, WithLevel(NewConsoleSink(), opts.ConsoleLevel))
 	}
+	f.sinks = append(f.sinks, sinks...)
+	if opts.Level == encode.ZeroLevel {
+		opts.Level = encode.ErrorLevel
+		for _, s := range f.sinks {
+			if s.Level < opts.Level {
+				opts.Level = s.Level
+			}
+		}
+	}
+	f.deflevel = opts.Level
+	f.SetLevels(opts.Level, nil)
 	go f.flushWorker()
 	return f
 }
 
+// logs pushed after flusher is closed are counted as dropped and are reported on its last flush,
+// or are printed, if drops are already reported
+func (f *Flusher) push(logslist [][]byte) {
+	var late int
+	f.mux.Lock()
+	for _, log := range logslist {
+		if !f.closed && len(f.queue) >= f.opts.QueueLength && !f.makeRoom(log) {
+			continue
+		}
+		if f.closed {
+			if f.reported {
+				late++
+			} else {
+				f.drop(encode.GetLogLvl(log))
+			}
+			continue
+		}
+		f.queue = append(f.queue, log)
+	}
+	f.mux.Unlock()
+	if late > 0 {
+		printLateDrops(late)
+		return
+	}
+
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+// must be called with locked mux. Returns false if log must be dropped
+func (f *Flusher) makeRoom(log []byte) bool {
+	lvl := encode.GetLogLvl(log)
+	switch f.opts.Overflow {
+	case DropLowest:
+		return f.dropLowest(lvl, false)
+	case Sample:
+		if lvl < encode.WarningLevel {
+			if f.sampled++; f.sampled < f.opts.SampleRate {
+				f.drop(lvl)
+				return false
+			}
+			f.sampled = 0
+		}
+		return f.dropLowest(lvl, true)
+	default:
+		for len(f.queue) >= f.opts.QueueLength && !f.closed {
+			select {
+			case f.notify <- struct{}{}:
+			default:
+			}
+			f.notfull.Wait()
+		}
+		return true
+	}
+}
+
+// drops the oldest of queued logs with lowest level, that is lower than lvl (or equal),
+// or reports that new one must be dropped
+func (f *Flusher) dropLowest(lvl encode.LogsFlushLevel, orequal bool) bool {
+	lowest := -1
+	for i := 0; i < len(f.queue); i++ {
+		if l := encode.GetLogLvl(f.queue[i]); (l < lvl || (orequal && l == lvl)) && (lowest == -1 || l < encode.GetLogLvl(f.queue[lowest])) {
+			lowest = i
+		}
+	}
+	if lowest == -1 {
+		f.drop(lvl)
+		return false
+	}
+	f.drop(encode.GetLogLvl(f.queue[lowest]))
+	f.queue = f.queue[:lowest+copy(f.queue[lowest:], f.queue[lowest+1:])]
+	return true
+}
+
+func (f *Flusher) drop(lvl encode.LogsFlushLevel) {
+	if int(lvl) < len(f.dropped) {
+		f.dropped[lvl]++
+	}
+}
+
+// counts logs as dropped, e.g. ones written into closed logger
+func (f *Flusher) dropLogs(logslist [][]byte) {
+	f.mux.Lock()
+	reported := f.reported
+	if !reported {
+		for _, log := range logslist {
+			f.drop(encode.GetLogLvl(log))
+		}
+	}
+	f.mux.Unlock()
+	if reported {
+		printLateDrops(len(logslist))
+	}
+}
+
+func printLateDrops(n int) {
+	encode.PrintLog(encode.EncodeLog(encode.Warning, time.Now(), flushertags, "Drop", "logs dropped after flusher is done", Int("count", n)))
+}
+
+func (f *Flusher) pop() [][]byte {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if len(f.queue) == 0 {
+		return nil
+	}
+	logslist := f.queue
+	f.queue = make([][]byte, 0, f.opts.QueueLength)
+	f.notfull.Broadcast()
+	return logslist
+}
+
 func (f *Flusher) flushWorker() {
-	//println("THIS") ////////////////////
+	ticker := time.NewTicker(f.opts.DropsReport)
+	defer ticker.Stop()
 	for {
 		select {
-		case logslist := <-f.ch:
-			for _, bytelog := range logslist {
-				if encode.GetLogLvl(bytelog) >= f.flushlvl {
-					encode.PrintLog(bytelog)
+		case <-f.notify:
+			f.flush(f.pop())
+		case <-ticker.C:
+			f.reportDrops()
+		case <-f.cancel:
+			f.mux.Lock()
+			f.closed = true
+			f.notfull.Broadcast()
+			f.mux.Unlock()
+
+			f.flush(f.pop())
+			f.mux.Lock()
+			f.reported = true
+			f.mux.Unlock()
+			f.reportDrops()
+			for _, s := range f.sinks {
+				if err := s.Close(); err != nil {
+					encode.PrintLog(encode.EncodeLog(encode.Error, time.Now(), flushertags, "Sink.Close", err.Error()))
 				}
 			}
-		case <-f.cancel:
-			for {
-				select {
-				case logslist := <-f.ch:
-					for _, bytelog := range logslist {
-						if encode.GetLogLvl(bytelog) >= f.flushlvl {
-							encode.PrintLog(bytelog)
-						}
-					}
-				default:
-					close(f.allflushed)
-					return
+			close(f.allflushed)
+			return
+		}
+	}
+}
+
+func (f *Flusher) reportDrops() {
+	f.mux.Lock()
+	dropped := f.dropped
+	f.dropped = [len(f.dropped)]int{}
+	f.mux.Unlock()
+
+	var total int
+	fields := make([]Field, 0, len(dropped))
+	for lvl := encode.DebugLevel; int(lvl) < len(dropped); lvl++ {
+		if dropped[lvl] > 0 {
+			total += dropped[lvl]
+			fields = append(fields, Int(encode.LogType(lvl).String(), dropped[lvl]))
+		}
+	}
+	if total > 0 {
+		f.flush([][]byte{encode.EncodeLog(encode.Warning, time.Now(), flushertags, "Overflow", "logs dropped on full queue or by closed loggers", fields...)})
+	}
+}
+
+func (f *Flusher) flush(logslist [][]byte) {
+	for _, bytelog := range logslist {
+		lvl := encode.GetLogLvl(bytelog)
+		for _, s := range f.sinks {
+			if lvl >= s.Level {
+				if err := s.Flush(bytelog); err != nil {
+					encode.PrintLog(encode.EncodeLog(encode.Error, time.Now(), flushertags, "Sink.Flush", err.Error()))
 				}
 			}
 		}
@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+// flusher without worker, so its queue is not emptied
+func newQueue(opts FlusherOptions) *Flusher {
+	f := &Flusher{opts: opts, queue: make([][]byte, 0, opts.QueueLength), notify: make(chan struct{}, 1)}
+	f.notfull = sync.NewCond(&f.mux)
+	return f
+}
+
+func typedLogs(types ...encode.LogType) [][]byte {
+	logs := make([][]byte, len(types))
+	for i, lt := range types {
+		logs[i] = encode.EncodeLog(lt, time.Now(), nil, "n", strings.Repeat("x", i))
+	}
+	return logs
+}
+
+func queueTypes(f *Flusher) string {
+	var s string
+	for _, log := range f.queue {
+		s += encode.LogType(encode.GetLogLvl(log)).String() + " "
+	}
+	return strings.TrimSpace(s)
+}
+
+func TestOverflow(t *testing.T) {
+	D, I, W, E := encode.Debug, encode.Info, encode.Warning, encode.Error
+	tests := []struct {
+		name    string
+		opts    FlusherOptions
+		queued  []encode.LogType
+		pushed  []encode.LogType
+		queue   string
+		dropped map[encode.LogType]int
+	}{
+		{"drop lowest queued", FlusherOptions{QueueLength: 3, Overflow: DropLowest}, []encode.LogType{I, D, W}, []encode.LogType{E}, "INF WRN ERR", map[encode.LogType]int{D: 1}},
+		{"drop oldest of lowest", FlusherOptions{QueueLength: 3, Overflow: DropLowest}, []encode.LogType{I, W, I}, []encode.LogType{W}, "WRN INF WRN", map[encode.LogType]int{I: 1}},
+		{"drop new lowest", FlusherOptions{QueueLength: 2, Overflow: DropLowest}, []encode.LogType{W, E}, []encode.LogType{D, I}, "WRN ERR", map[encode.LogType]int{D: 1, I: 1}},
+		{"drop new of equal level", FlusherOptions{QueueLength: 2, Overflow: DropLowest}, []encode.LogType{W, W}, []encode.LogType{W}, "WRN WRN", map[encode.LogType]int{W: 1}},
+		{"sample infos", FlusherOptions{QueueLength: 2, Overflow: Sample, SampleRate: 3}, []encode.LogType{I, I}, []encode.LogType{I, I, I}, "INF INF", map[encode.LogType]int{I: 3}},
+		{"sample keeps warnings", FlusherOptions{QueueLength: 2, Overflow: Sample, SampleRate: 3}, []encode.LogType{D, W}, []encode.LogType{W, W}, "WRN WRN", map[encode.LogType]int{D: 1, W: 1}},
+		{"sample drops new lower", FlusherOptions{QueueLength: 2, Overflow: Sample, SampleRate: 3}, []encode.LogType{E, E}, []encode.LogType{W}, "ERR ERR", map[encode.LogType]int{W: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newQueue(tt.opts)
+			f.push(typedLogs(tt.queued...))
+			f.push(typedLogs(tt.pushed...))
+			if got := queueTypes(f); got != tt.queue {
+				t.Fatalf("queue %q, want %q", got, tt.queue)
+			}
+			for lvl := encode.DebugLevel; int(lvl) < len(f.dropped); lvl++ {
+				if f.dropped[lvl] != tt.dropped[encode.LogType(lvl)] {
+					t.Fatalf("dropped %v, want %v", f.dropped, tt.dropped)
+				}
+			}
+		})
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	f := newQueue(FlusherOptions{QueueLength: 1, Overflow: Block})
+	f.push(typedLogs(encode.Info))
+	pushed := make(chan struct{})
+	go func() {
+		f.push(typedLogs(encode.Debug))
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push to full queue is not blocked")
+	case <-time.After(time.Millisecond * 50):
+	}
+	if logs := f.pop(); len(logs) != 1 {
+		t.Fatalf("popped %d logs, want 1", len(logs))
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push is not unblocked by pop")
+	}
+	if got := queueTypes(f); got != "DBG" {
+		t.Fatalf("queue %q, want %q", got, "DBG")
+	}
+}
+
+type recordingSink struct {
+	logs [][]byte
+}
+
+func (s *recordingSink) Flush(log []byte) error {
+	s.logs = append(s.logs, log)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestReportDrops(t *testing.T) {
+	sink := &recordingSink{}
+	f := newQueue(FlusherOptions{QueueLength: 1, Overflow: DropLowest})
+	f.sinks = []LeveledSink{WithLevel(sink, encode.DebugLevel)}
+	f.push(typedLogs(encode.Error, encode.Debug, encode.Info, encode.Info))
+	f.reportDrops()
+	if len(sink.logs) != 1 {
+		t.Fatalf("flushed %d reports, want 1", len(sink.logs))
+	}
+	rec, err := encode.Decode(sink.logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Type != encode.Warning || rec.Fields.String() != " DBG=1 INF=2" {
+		t.Fatalf("report %v%s", rec.Message, rec.Fields.String())
+	}
+	// counters are reset
+	f.reportDrops()
+	if len(sink.logs) != 1 {
+		t.Fatal("drops are reported twice")
+	}
+}
+
+func TestPushAfterClose(t *testing.T) {
+	f := newQueue(FlusherOptions{QueueLength: 4})
+	f.closed = true
+	f.push(typedLogs(encode.Info, encode.Error))
+	if len(f.queue) != 0 || f.dropped[encode.InfoLevel] != 1 || f.dropped[encode.ErrorLevel] != 1 {
+		t.Fatalf("queue %q, dropped %v, want empty queue and dropped info and error", queueTypes(f), f.dropped)
+	}
+	// printed after last report
+	f.reported = true
+	f.push(typedLogs(encode.Warning))
+	if f.dropped[encode.WarningLevel] != 0 {
+		t.Fatalf("dropped %v after last report", f.dropped)
+	}
+
+	sink := &recordingSink{}
+	fl := NewFlusherWithOptions(FlusherOptions{}, WithLevel(sink, encode.DebugLevel)).(*Flusher)
+	fl.Close()
+	fl.push(typedLogs(encode.Error)) // before or after worker is closed, or after last report
+	<-fl.Done()
+	if len(sink.logs) > 1 {
+		t.Fatalf("flushed %d logs", len(sink.logs))
+	}
+	for _, log := range sink.logs {
+		if rec, err := encode.Decode(log); err != nil || (rec.Type != encode.Error && rec.Fields.String() != " ERR=1") {
+			t.Fatalf("flushed %v, %v, want the log or drops report", rec, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+// levels are checked by loggers before encoding, so filtered logs cost nothing.
+// override is applied to loggers, whose tags start with override's tags
+type levelsconf struct {
+	def       encode.LogsFlushLevel
+	overrides []leveloverride // longest tags first
+}
+
+type leveloverride struct {
+	tags []byte // encoded as in logs: "[tag1] [tag2] "
+	lvl  encode.LogsFlushLevel
+}
+
+// cached level of logger, recalculated when flusher's levels are changed
+type cachedlevel struct {
+	conf *levelsconf
+	lvl  encode.LogsFlushLevel
+}
+
+func (f *Flusher) level(tags []byte, cache *atomic.Pointer[cachedlevel]) encode.LogsFlushLevel {
+	conf := f.levels.Load()
+	if c := cache.Load(); c != nil && c.conf == conf {
+		return c.lvl
+	}
+	c := &cachedlevel{conf: conf, lvl: conf.def}
+	if len(tags) > 11 {
+		for _, o := range conf.overrides {
+			if strings.HasPrefix(string(tags[11:]), string(o.tags)) {
+				c.lvl = o.lvl
+				break
+			}
+		}
+	}
+	cache.Store(c)
+	return c.lvl
+}
+
+// overrides keys are tags, e.g. "[data2db] [Upload Routine]" or "data2db"
+func (f *Flusher) SetLevels(def encode.LogsFlushLevel, overrides map[string]encode.LogsFlushLevel) {
+	conf := &levelsconf{def: def, overrides: make([]leveloverride, 0, len(overrides))}
+	for k, lvl := range overrides {
+		conf.overrides = append(conf.overrides, leveloverride{tags: encode.AppendTags(nil, parseTagsKey(k)...)[11:], lvl: lvl})
+	}
+	for i := 1; i < len(conf.overrides); i++ {
+		for k := i; k > 0 && len(conf.overrides[k].tags) > len(conf.overrides[k-1].tags); k-- {
+			conf.overrides[k], conf.overrides[k-1] = conf.overrides[k-1], conf.overrides[k]
+		}
+	}
+	f.levels.Store(conf)
+}
+
+func parseTagsKey(key string) []string {
+	key = strings.TrimSpace(key)
+	if !strings.HasPrefix(key, "[") {
+		return []string{key}
+	}
+	tags := make([]string, 0, 2)
+	for _, t := range strings.Split(key, "] [") {
+		tags = append(tags, strings.Trim(t, "[]"))
+	}
+	return tags
+}
+
+// file's lines are "default LVL" or "[tag1] [tag2] LVL", where LVL is DBG, INF, WRN, ERR or number 1-4.
+// empty lines and lines starting with # are skipped. Without default line flusher's level is default,
+// so removed lines are reset on reload
+func (f *Flusher) LoadLevelsFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	def := f.deflevel
+	overrides := make(map[string]encode.LogsFlushLevel)
+	sc := bufio.NewScanner(file)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexAny(line, " \t")
+		if i == -1 {
+			return errors.New(path + ": no level on line " + strconv.Itoa(n))
+		}
+		lvl, err := parseLevel(line[i+1:])
+		if err != nil {
+			return errors.New(path + ": line " + strconv.Itoa(n) + ": " + err.Error())
+		}
+		if key := strings.TrimSpace(line[:i]); key == "default" {
+			def = lvl
+		} else {
+			overrides[key] = lvl
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return err
+	}
+	f.SetLevels(def, overrides)
+	return nil
+}
+
+func parseLevel(s string) (encode.LogsFlushLevel, error) {
+	if lt, ok := encode.ParseLogType(strings.ToUpper(s)); ok {
+		return encode.LogsFlushLevel(lt), nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= int(encode.DebugLevel) && n <= int(encode.ErrorLevel) {
+		return encode.LogsFlushLevel(n), nil
+	}
+	return 0, errors.New("unknown level: " + s)
+}
+
+// loads levels file and reloads it on every SIGHUP, until flusher is closed
+func (f *Flusher) ReloadLevelsOnSighup(path string) error {
+	if err := f.LoadLevelsFile(path); err != nil {
+		return err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				if err := f.LoadLevelsFile(path); err != nil {
+					f.push([][]byte{encode.EncodeLog(encode.Error, time.Now(), flushertags, "LoadLevelsFile", err.Error())})
+				} else {
+					f.push([][]byte{encode.EncodeLog(encode.Info, time.Now(), flushertags, "LoadLevelsFile", "levels reloaded")})
+				}
+			case <-f.cancel:
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    encode.LogsFlushLevel
+		wanterr bool
+	}{
+		{"DBG", encode.DebugLevel, false},
+		{"wrn", encode.WarningLevel, false},
+		{"1", encode.DebugLevel, false},
+		{"4", encode.ErrorLevel, false},
+		{"0", 0, true},
+		{"5", 0, true},
+		{"NON", 0, true},
+	}
+	for _, tt := range tests {
+		lvl, err := parseLevel(tt.s)
+		if (err != nil) != tt.wanterr || lvl != tt.want {
+			t.Errorf("parseLevel(%q) = %d, %v, want %d, err %v", tt.s, lvl, err, tt.want, tt.wanterr)
+		}
+	}
+}
+
+func TestLoadLevelsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logslevels.txt")
+	f := NewFlusherWithOptions(FlusherOptions{Level: encode.WarningLevel}).(*Flusher)
+	defer f.Close()
+	levelOf := func(tags ...string) encode.LogsFlushLevel {
+		return f.level(encode.AppendTags(nil, tags...), new(atomic.Pointer[cachedlevel]))
+	}
+	type want struct {
+		tags []string
+		lvl  encode.LogsFlushLevel
+	}
+	tests := []struct {
+		name    string
+		file    string
+		wanterr bool
+		levels  []want
+	}{
+		{"default and overrides", "# comment\n\ndefault INF\ndata2db ERR\n[data2db] [Upload Routine] 1\n", false, []want{
+			{[]string{"emailer"}, encode.InfoLevel},
+			{[]string{"data2db"}, encode.ErrorLevel},
+			{[]string{"data2db", "Categorize"}, encode.ErrorLevel},
+			{[]string{"data2db", "Upload Routine", "price.csv"}, encode.DebugLevel},
+		}},
+		{"removed lines are reset", "data2db DBG\n", false, []want{
+			{[]string{"emailer"}, encode.WarningLevel},
+			{[]string{"data2db", "Upload Routine"}, encode.DebugLevel},
+		}},
+		{"bad file keeps levels", "data2db ERR\ndefault 0\n", true, []want{
+			{[]string{"emailer"}, encode.WarningLevel},
+			{[]string{"data2db"}, encode.DebugLevel},
+		}},
+		{"no level", "data2db\n", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := f.LoadLevelsFile(path)
+			if (err != nil) != tt.wanterr {
+				t.Fatalf("LoadLevelsFile() = %v, want err %v", err, tt.wanterr)
+			}
+			for _, w := range tt.levels {
+				if lvl := levelOf(w.tags...); lvl != w.lvl {
+					t.Fatalf("level of %s = %d, want %d", strings.Join(w.tags, ","), lvl, w.lvl)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadLevelsOnSighup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logslevels.txt")
+	if err := os.WriteFile(path, []byte("default ERR\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFlusherWithOptions(FlusherOptions{Level: encode.WarningLevel}).(*Flusher)
+	defer f.Close()
+	if err := f.ReloadLevelsOnSighup(path); err != nil {
+		t.Fatal(err)
+	}
+	if lvl := f.levels.Load().def; lvl != encode.ErrorLevel {
+		t.Fatalf("loaded default %d, want %d", lvl, encode.ErrorLevel)
+	}
+	if err := os.WriteFile(path, []byte("# no default\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); f.levels.Load().def != encode.WarningLevel; time.Sleep(time.Millisecond * 10) {
+		if time.Since(start) > time.Second {
+			t.Fatal("levels are not reloaded on SIGHUP")
+		}
+	}
+}
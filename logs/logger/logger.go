@@ -1,6 +1,10 @@
 package logger
 
-import "time"
+import (
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
 
 type Logger interface {
 	LogsWriter
@@ -11,17 +15,20 @@ type Logger interface {
 type PackageLogger interface {
 	LogsWriter
 	Flush()
+	Close()
 }
 
 type LogsWriter interface {
-	Debug(string, string)
-	Info(string, string)
-	Warning(string, string)
-	Error(string, error)
+	Debug(name, logstr string, fields ...Field)
+	Info(name, logstr string, fields ...Field)
+	Warning(name, logstr string, fields ...Field)
+	Error(name string, logerr error, fields ...Field)
 }
 
 type LogsFlusher interface {
 	NewLogsContainer(tags ...string) Logger
+	SetLevels(def encode.LogsFlushLevel, overrides map[string]encode.LogsFlushLevel)
+	ReloadLevelsOnSighup(path string) error
 	Close()
 	Done() <-chan struct{}
 	DoneWithTimeout(timeout time.Duration)
@@ -1,43 +1,128 @@
 package logger
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/okonma-violet/spec/logs/encode"
 )
 
+// collects logs and sends them to flusher by packages: when logsBufLen logs are collected,
+// when the oldest of them is PackageFlushAge old, or on Flush. Safe for concurrent use.
+// Logs written after Close are counted by flusher as dropped
 type PackageLogsContainer struct {
-	ch   chan [][]byte
+	f    *Flusher
 	tags []byte
-	list [][]byte
+	lvl  atomic.Pointer[cachedlevel]
+
+	pushmux sync.Mutex // keeps order of packages, logging is not blocked by push into full queue
+	mux     sync.Mutex
+	list    [][]byte
+	buflen  int
+	timer   *time.Timer
+	closed  bool
 }
 
+const PackageFlushAge = time.Second
+
+const packagebuflen = 16
+
 func (l *LogsContainer) NewPackageSubLogger(logsBufLen int, tags ...string) PackageLogger {
-	return &PackageLogsContainer{ch: l.ch, tags: encode.AppendTags(l.tags, tags...), list: make([][]byte, 0, logsBufLen)}
+	if logsBufLen <= 0 {
+		logsBufLen = packagebuflen
+	}
+	return &PackageLogsContainer{f: l.f, tags: encode.AppendTags(l.tags, tags...), list: make([][]byte, 0, logsBufLen), buflen: logsBufLen}
 }
 
-func (l *PackageLogsContainer) Debug(name, logstr string) {
-	l.list = append(l.list, encode.EncodeLog(encode.Debug, time.Now(), l.tags, name, logstr))
+func (l *PackageLogsContainer) Debug(name, logstr string, fields ...Field) {
+	if encode.DebugLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
+	l.add(encode.EncodeLog(encode.Debug, time.Now(), l.tags, name, logstr, fields...))
 }
 
-func (l *PackageLogsContainer) Info(name, logstr string) {
-	l.list = append(l.list, encode.EncodeLog(encode.Info, time.Now(), l.tags, name, logstr))
+func (l *PackageLogsContainer) Info(name, logstr string, fields ...Field) {
+	if encode.InfoLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
+	l.add(encode.EncodeLog(encode.Info, time.Now(), l.tags, name, logstr, fields...))
 }
 
-func (l *PackageLogsContainer) Warning(name, logstr string) {
-	l.list = append(l.list, encode.EncodeLog(encode.Warning, time.Now(), l.tags, name, logstr))
+func (l *PackageLogsContainer) Warning(name, logstr string, fields ...Field) {
+	if encode.WarningLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
+	l.add(encode.EncodeLog(encode.Warning, time.Now(), l.tags, name, logstr, fields...))
 }
 
-func (l *PackageLogsContainer) Error(name string, logerr error) {
+func (l *PackageLogsContainer) Error(name string, logerr error, fields ...Field) {
+	if encode.ErrorLevel < l.f.level(l.tags, &l.lvl) {
+		return
+	}
 	var logstr string
 	if logerr != nil {
 		logstr = logerr.Error()
 	} else {
 		logstr = "nil err"
 	}
-	l.list = append(l.list, encode.EncodeLog(encode.Error, time.Now(), l.tags, name, logstr))
+	l.add(encode.EncodeLog(encode.Error, time.Now(), l.tags, name, logstr, fields...))
+}
+
+func (l *PackageLogsContainer) add(log []byte) {
+	l.mux.Lock()
+	if l.closed {
+		l.mux.Unlock()
+		l.f.dropLogs([][]byte{log})
+		return
+	}
+	l.list = append(l.list, log)
+	if len(l.list) < l.buflen {
+		if l.timer == nil {
+			l.timer = time.AfterFunc(PackageFlushAge, l.Flush)
+		}
+		l.mux.Unlock()
+		return
+	}
+	l.mux.Unlock()
+	l.Flush()
 }
 
 func (l *PackageLogsContainer) Flush() {
-	l.ch <- l.list
+	l.pushmux.Lock()
+	defer l.pushmux.Unlock()
+	l.mux.Lock()
+	list := l.swap()
+	l.mux.Unlock()
+	if len(list) > 0 {
+		l.f.push(list)
+	}
+}
+
+// must be called with locked mux. Returns collected logs
+func (l *PackageLogsContainer) swap() [][]byte {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	if len(l.list) == 0 {
+		return nil
+	}
+	list := l.list
+	l.list = make([][]byte, 0, l.buflen)
+	return list
+}
+
+// flushes collected logs
+func (l *PackageLogsContainer) Close() {
+	l.pushmux.Lock()
+	defer l.pushmux.Unlock()
+	l.mux.Lock()
+	list := l.swap()
+	l.closed = true
+	l.list = nil
+	l.mux.Unlock()
+	if len(list) > 0 {
+		l.f.push(list)
+	}
 }
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+func queued(f *Flusher) int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.queue)
+}
+
+func TestPackageLogger(t *testing.T) {
+	f := newQueue(FlusherOptions{QueueLength: 100})
+	f.SetLevels(encode.DebugLevel, nil)
+	l := (&LogsContainer{f: f}).NewPackageSubLogger(3, "pkg")
+
+	// by size
+	for i := 0; i < 2; i++ {
+		l.Info("test", "collected")
+	}
+	if n := queued(f); n != 0 {
+		t.Fatalf("%d logs are pushed before buffer is full", n)
+	}
+	l.Info("test", "collected")
+	if n := queued(f); n != 3 {
+		t.Fatalf("%d logs are pushed on full buffer, want 3", n)
+	}
+
+	// by age
+	l.Debug("test", "old")
+	if n := queued(f); n != 3 {
+		t.Fatalf("%d logs are pushed before flush age, want 3", n)
+	}
+	for start := time.Now(); queued(f) != 4; time.Sleep(time.Millisecond * 50) {
+		if time.Since(start) > PackageFlushAge*3 {
+			t.Fatal("log is not pushed after flush age")
+		}
+	}
+
+	// on close, then dropped
+	l.Warning("test", "before close")
+	l.Close()
+	if n := queued(f); n != 5 {
+		t.Fatalf("%d logs are pushed on close, want 5", n)
+	}
+	l.Error("test", nil)
+	l.Flush()
+	if n := queued(f); n != 5 {
+		t.Fatalf("log written after close is pushed")
+	}
+	if f.dropped[encode.ErrorLevel] != 1 {
+		t.Fatalf("dropped %v, want one error", f.dropped)
+	}
+}
+
+func TestPackageLoggerBlockedPush(t *testing.T) {
+	f := newQueue(FlusherOptions{QueueLength: 1, Overflow: Block})
+	f.SetLevels(encode.DebugLevel, nil)
+	l := (&LogsContainer{f: f}).NewPackageSubLogger(2, "pkg")
+	go func() {
+		l.Info("test", "first")
+		l.Info("test", "second") // pushing is blocked on full queue
+	}()
+	for start := time.Now(); queued(f) != 1; time.Sleep(time.Millisecond * 10) {
+		if time.Since(start) > time.Second {
+			t.Fatal("logs are not pushed")
+		}
+	}
+	logged := make(chan struct{})
+	go func() {
+		l.Info("test", "third")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(time.Second):
+		t.Fatal("logging is blocked by push")
+	}
+	f.pop()
+}
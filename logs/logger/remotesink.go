@@ -0,0 +1,251 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+const remote_dialtimeout = time.Second
+const remote_writetimeout = time.Second * 5
+const remote_minredial = time.Second
+const remote_maxredial = time.Minute
+const remote_maxspool = 64 << 20 // bytes
+
+var remotesinktags = encode.AppendTags(nil, "logger", "RemoteSink")
+
+// sends frames to logsserver, while it is not available appends them to spool file
+// and replays spool on reconnect. Delivery is best-effort: logsserver sends no acks, so frames
+// written into connection, that broke before server read them, are lost. Spool replay
+// interrupted by connection loss is repeated from the beginning, so replayed logs may be duplicated.
+// Server is dialed and spool is replayed in background, so flusher is not stalled by dead server
+// or long replay: spool is handed over to background as spoolpath.replay, new logs are spooled meanwhile.
+// Logs, that don't fit into full spool, are dropped and their number is reported after replay
+type RemoteSink struct {
+	network   string
+	addr      string
+	spoolpath string
+
+	conn     net.Conn
+	dialing  bool
+	dialed   chan net.Conn // nil on dial or replay error
+	closing  chan struct{}
+	nextdial time.Time
+	redial   time.Duration
+
+	spool      *os.File
+	spooled    bool
+	spoolsize  int64
+	replaysize int64 // of spool handed over to background
+	maxspool   int64
+	dropped    int
+}
+
+// network is "tcp" or "unix"
+func NewRemoteSink(network, addr, spoolpath string) (*RemoteSink, error) {
+	if addr == "" || spoolpath == "" {
+		return nil, errors.New("empty logsserver addr or spool path")
+	}
+	if network == "" {
+		network = "tcp"
+	}
+	s := &RemoteSink{network: network, addr: addr, spoolpath: spoolpath, redial: remote_minredial, dialed: make(chan net.Conn, 1), closing: make(chan struct{}), maxspool: remote_maxspool}
+	if err := s.openSpool(); err != nil {
+		return nil, err
+	}
+	// left by interrupted replay
+	if fi, err := os.Stat(s.replaypath()); err == nil {
+		s.replaysize = fi.Size()
+	}
+	return s, nil
+}
+
+func (s *RemoteSink) openSpool() error {
+	spool, err := os.OpenFile(s.spoolpath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := spool.Stat()
+	if err != nil {
+		spool.Close()
+		return err
+	}
+	s.spool, s.spooled, s.spoolsize = spool, fi.Size() > 0, fi.Size()
+	return nil
+}
+
+func (s *RemoteSink) replaypath() string {
+	return s.spoolpath + ".replay"
+}
+
+func (s *RemoteSink) Flush(log []byte) error {
+	if s.conn == nil {
+		s.connect()
+	}
+	if s.conn != nil {
+		if err := s.write(log); err == nil {
+			return nil
+		}
+		s.disconnect()
+	}
+	return s.spoolLog(log)
+}
+
+// starts dial and replay in background or takes their result
+func (s *RemoteSink) connect() {
+	if !s.dialing {
+		if time.Now().Before(s.nextdial) {
+			return
+		}
+		s.background(nil)
+		return
+	}
+	var conn net.Conn
+	select {
+	case conn = <-s.dialed:
+		s.dialing = false
+	default:
+		return
+	}
+	if conn == nil {
+		s.nextdial = time.Now().Add(s.redial)
+		if s.redial *= 2; s.redial > remote_maxredial {
+			s.redial = remote_maxredial
+		}
+		return
+	}
+	s.redial, s.replaysize = remote_minredial, 0
+	// logs were spooled while replaying
+	if s.spooled {
+		s.background(conn)
+		return
+	}
+	s.conn = conn
+	if s.dropped > 0 {
+		if err := s.write(encode.EncodeLog(encode.Warning, time.Now(), remotesinktags, "Spool", "logs dropped on full spool", Int("dropped", s.dropped))); err != nil {
+			s.disconnect()
+			return
+		}
+		s.dropped = 0
+	}
+}
+
+// hands spool over, if there is no unreplayed one, and dials (if conn is nil) and replays in background
+func (s *RemoteSink) background(conn net.Conn) {
+	if s.spooled && s.replaysize == 0 {
+		if err := s.handover(); err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			s.nextdial = time.Now().Add(s.redial)
+			return
+		}
+	}
+	s.dialing = true
+	go func() {
+		if conn == nil {
+			conn, _ = net.DialTimeout(s.network, s.addr, remote_dialtimeout)
+		}
+		if conn != nil {
+			if err := replay(conn, s.replaypath(), s.closing); err != nil {
+				conn.Close()
+				conn = nil
+			}
+		}
+		s.dialed <- conn
+	}()
+}
+
+func (s *RemoteSink) handover() error {
+	if err := s.spool.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.spoolpath, s.replaypath()); err != nil {
+		s.openSpool()
+		return err
+	}
+	s.replaysize = s.spoolsize
+	return s.openSpool()
+}
+
+func (s *RemoteSink) disconnect() {
+	s.conn.Close()
+	s.conn = nil
+	s.nextdial = time.Now().Add(s.redial)
+}
+
+func (s *RemoteSink) write(log []byte) error {
+	return writeFrame(s.conn, log)
+}
+
+func writeFrame(conn net.Conn, log []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(remote_writetimeout))
+	return encode.WriteFrame(conn, log)
+}
+
+// spool and handed over one are limited together
+func (s *RemoteSink) spoolLog(log []byte) error {
+	size := int64(encode.FrameHeaderLength + len(log))
+	if s.spoolsize+s.replaysize+size > s.maxspool {
+		s.dropped++
+		return nil
+	}
+	if err := encode.WriteFrame(s.spool, log); err != nil {
+		return err
+	}
+	s.spooled, s.spoolsize = true, s.spoolsize+size
+	return nil
+}
+
+// replays and removes handed over spool, if there is one. Is interrupted by closing
+func replay(conn net.Conn, path string, closing <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		select {
+		case <-closing:
+			return errors.New("sink is closed")
+		default:
+		}
+		log, err := encode.ReadFrame(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, encode.ErrBadFrame) { // broken tail, skip it
+				break
+			}
+			return err
+		}
+		if err = writeFrame(conn, log); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// interrupts background replay, not replayed spool is left for next start
+func (s *RemoteSink) Close() error {
+	close(s.closing)
+	if s.dialing {
+		if conn := <-s.dialed; conn != nil {
+			conn.Close()
+		}
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return s.spool.Close()
+}
@@ -0,0 +1,183 @@
+package logger
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+// logsserver on unix socket, sends messages of received logs into returned chan
+func testServer(t *testing.T, sockpath string) <-chan string {
+	t.Helper()
+	ln, err := net.Listen("unix", sockpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan string, 64)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					log, err := encode.ReadFrame(r)
+					if err != nil {
+						return
+					}
+					rec, err := encode.Decode(log)
+					if err != nil {
+						return
+					}
+					got <- rec.Message
+				}
+			}()
+		}
+	}()
+	return got
+}
+
+// polls background dial and replay until sink writes into connection
+func waitConnected(t *testing.T, s *RemoteSink) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for s.conn == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("sink is not connected")
+		}
+		if !s.dialing {
+			s.nextdial = time.Time{}
+		}
+		s.connect()
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// dial to not existing socket fails
+func waitDialFailed(t *testing.T, s *RemoteSink) {
+	t.Helper()
+	for s.dialing {
+		time.Sleep(time.Millisecond * 5)
+		s.connect()
+	}
+}
+
+func flushMessages(t *testing.T, s *RemoteSink, msgs ...string) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := s.Flush(encode.EncodeLog(encode.Info, time.Now(), nil, "n", msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectMessages(t *testing.T, got <-chan string, msgs ...string) {
+	t.Helper()
+	for _, want := range msgs {
+		select {
+		case msg := <-got:
+			if msg != want {
+				t.Fatalf("received %q, want %q", msg, want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%q is not received", want)
+		}
+	}
+}
+
+func TestRemoteSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+	sockpath := filepath.Join(dir, "s.sock")
+	s, err := NewRemoteSink("unix", sockpath, filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	flushMessages(t, s, "1")
+	waitDialFailed(t, s)
+	flushMessages(t, s, "2", "3")
+	if s.spoolsize == 0 {
+		t.Fatal("logs are not spooled")
+	}
+
+	got := testServer(t, sockpath)
+	s.nextdial = time.Time{}
+	// spool is handed over, new logs are spooled while replaying
+	flushMessages(t, s, "4")
+	if !s.dialing || s.replaysize == 0 {
+		t.Fatal("spool is not handed over to background")
+	}
+	flushMessages(t, s, "5")
+	waitConnected(t, s)
+	flushMessages(t, s, "6")
+	expectMessages(t, got, "1", "2", "3", "4", "5", "6")
+	if s.spoolsize != 0 || s.replaysize != 0 {
+		t.Fatalf("spool size %d, replay size %d after replay", s.spoolsize, s.replaysize)
+	}
+	if _, err = os.Stat(s.replaypath()); !os.IsNotExist(err) {
+		t.Fatal("replayed spool is not removed")
+	}
+}
+
+func TestRemoteSpoolCap(t *testing.T) {
+	dir := t.TempDir()
+	sockpath := filepath.Join(dir, "s.sock")
+	s, err := NewRemoteSink("unix", sockpath, filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	framesize := int64(encode.FrameHeaderLength + len(encode.EncodeLog(encode.Info, time.Now(), nil, "n", "0")))
+	s.maxspool = framesize * 3
+
+	flushMessages(t, s, "0")
+	waitDialFailed(t, s)
+	for i := 1; i < 5; i++ {
+		flushMessages(t, s, strconv.Itoa(i))
+	}
+	if s.spoolsize > s.maxspool || s.dropped != 2 {
+		t.Fatalf("spool size %d of %d, dropped %d, want 2", s.spoolsize, s.maxspool, s.dropped)
+	}
+
+	got := testServer(t, sockpath)
+	waitConnected(t, s)
+	expectMessages(t, got, "0", "1", "2", "logs dropped on full spool")
+	if s.dropped != 0 {
+		t.Fatal("dropped logs are not reset after report")
+	}
+}
+
+func TestRemoteReplayLeftover(t *testing.T) {
+	dir := t.TempDir()
+	sockpath, spoolpath := filepath.Join(dir, "s.sock"), filepath.Join(dir, "spool")
+	// replay was interrupted, then logs were spooled
+	var replayed, spooled []byte
+	replayed = encode.AppendFrame(replayed, encode.EncodeLog(encode.Info, time.Now(), nil, "n", "old"))
+	spooled = encode.AppendFrame(spooled, encode.EncodeLog(encode.Info, time.Now(), nil, "n", "newer"))
+	if err := os.WriteFile(spoolpath+".replay", replayed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(spoolpath, spooled, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := testServer(t, sockpath)
+	s, err := NewRemoteSink("unix", sockpath, spoolpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	waitConnected(t, s)
+	flushMessages(t, s, "newest")
+	expectMessages(t, got, "old", "newer", "newest")
+}
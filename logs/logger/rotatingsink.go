@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+const rotated_layout = "20060102-150405.000000"
+
+// failed rotation is not retried until then, logs keep going into current file
+const rotate_retry = time.Minute
+
+type RotateOptions struct {
+	MaxSize    int64         // bytes, zero means no rotation by size
+	Interval   time.Duration // zero means no rotation by time
+	MaxBackups int           // zero means keeping all rotated files
+	MaxAge     time.Duration // zero means keeping all rotated files
+}
+
+// plain text file, that is renamed to path.<time> on rotation
+type RotatingFileSink struct {
+	path   string
+	opts   RotateOptions
+	f      *os.File
+	size   int64
+	opened time.Time
+	retry  time.Time // after failed rotation
+}
+
+func NewRotatingFileSink(path string, opts RotateOptions) (*RotatingFileSink, error) {
+	s := &RotatingFileSink{path: path, opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RotatingFileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size, s.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// line is written even if rotation failed, rotation is retried after rotate_retry then
+func (s *RotatingFileSink) Flush(log []byte) error {
+	line := encode.DecodeToString(log) + "\n"
+	var rotateerr error
+	if ((s.opts.MaxSize > 0 && s.size+int64(len(line)) > s.opts.MaxSize && s.size > 0) ||
+		(s.opts.Interval > 0 && time.Since(s.opened) >= s.opts.Interval)) && !time.Now().Before(s.retry) {
+		if rotateerr = s.rotate(); rotateerr != nil {
+			s.retry = time.Now().Add(rotate_retry)
+		}
+	}
+	n, err := s.f.WriteString(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateerr
+}
+
+// file is renamed while it is still open, so on failure logs keep going into current file
+func (s *RotatingFileSink) rotate() error {
+	if err := os.Rename(s.path, s.path+"."+time.Now().Format(rotated_layout)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// not existing path means it was renamed, but reopening failed last time
+	old := s.f
+	if err := s.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return s.cleanup()
+}
+
+// only files with rotation time suffix are removed
+func (s *RotatingFileSink) cleanup() error {
+	if s.opts.MaxBackups <= 0 && s.opts.MaxAge <= 0 {
+		return nil
+	}
+	matched, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return err
+	}
+	rotated := matched[:0]
+	for _, name := range matched {
+		if _, err := time.Parse(rotated_layout, strings.TrimPrefix(name, s.path+".")); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+	// names ends with sortable time
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+	for i, name := range rotated {
+		if s.opts.MaxBackups > 0 && i >= s.opts.MaxBackups {
+			os.Remove(name)
+			continue
+		}
+		if s.opts.MaxAge > 0 {
+			if fi, err := os.Stat(name); err == nil && time.Since(fi.ModTime()) > s.opts.MaxAge {
+				os.Remove(name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+func rotated(t *testing.T, path string) []string {
+	t.Helper()
+	matched, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matched
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.txt")
+	s, err := NewRotatingFileSink(path, RotateOptions{MaxSize: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		if err = s.Flush(encode.EncodeLog(encode.Info, time.Now(), nil, "n", strings.Repeat("x", 60))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond) // rotated names are in microseconds
+	}
+	if n := len(rotated(t, path)); n != 1 {
+		t.Fatalf("%d rotated files, want 1", n)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() == 0 || fi.Size() > 250 {
+		t.Fatalf("current file size %d", fi.Size())
+	}
+}
+
+func TestRotateCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs.txt")
+	old := time.Now().Add(-time.Hour * 48)
+	for i, name := range []string{
+		"logs.txt." + old.Format(rotated_layout),
+		"logs.txt." + old.Add(time.Hour).Format(rotated_layout),
+		"logs.txt." + time.Now().Add(-time.Hour).Format(rotated_layout),
+		"logs.txt.bak", // not rotated one
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			os.Chtimes(p, old, old)
+		}
+	}
+	tests := []struct {
+		name string
+		opts RotateOptions
+		kept int // with .bak and just rotated one
+	}{
+		{"by age", RotateOptions{Interval: time.Nanosecond, MaxAge: time.Hour * 24}, 3},
+		{"by count", RotateOptions{Interval: time.Nanosecond, MaxBackups: 1}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewRotatingFileSink(path, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			time.Sleep(time.Millisecond)
+			if err = s.Flush(encode.EncodeLog(encode.Info, time.Now(), nil, "n", "msg")); err != nil {
+				t.Fatal(err)
+			}
+			left := rotated(t, path)
+			if len(left) != tt.kept {
+				t.Fatalf("left %v, want %d files", left, tt.kept)
+			}
+			if _, err = os.Stat(path + ".bak"); err != nil {
+				t.Fatal("not rotated file is removed")
+			}
+		})
+	}
+}
+
+func TestRotateRetry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "logs.txt")
+	s, err := NewRotatingFileSink(path, RotateOptions{Interval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// file can not be reopened
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	log := encode.EncodeLog(encode.Info, time.Now(), nil, "n", "msg")
+	if err = s.Flush(log); err == nil {
+		t.Fatal("rotation did not fail")
+	}
+	if s.size == 0 {
+		t.Fatal("log is not written on failed rotation")
+	}
+	if err = s.Flush(log); err != nil {
+		t.Fatalf("rotation is retried before rotate_retry: %v", err)
+	}
+	s.retry = time.Now()
+	if err = s.Flush(log); err == nil {
+		t.Fatal("rotation is not retried after rotate_retry")
+	}
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+// gets encoded logs from flusher's single goroutine, so implementations need no sync
+type Sink interface {
+	Flush(log []byte) error
+	Close() error
+}
+
+type LeveledSink struct {
+	Sink
+	Level encode.LogsFlushLevel
+}
+
+func WithLevel(s Sink, lvl encode.LogsFlushLevel) LeveledSink {
+	return LeveledSink{Sink: s, Level: lvl}
+}
+
+// colorized stderr output
+type ConsoleSink struct{}
+
+func NewConsoleSink() *ConsoleSink {
+	return &ConsoleSink{}
+}
+
+func (s *ConsoleSink) Flush(log []byte) error {
+	encode.PrintLog(log)
+	return nil
+}
+
+func (s *ConsoleSink) Close() error {
+	return nil
+}
+
+// plain text, one log per line
+type FileSink struct {
+	f *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Flush(log []byte) error {
+	_, err := s.f.WriteString(encode.DecodeToString(log) + "\n")
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// newline-delimited json on stdout
+type JSONSink struct {
+	enc *json.Encoder
+}
+
+func NewJSONSink() *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(os.Stdout)}
+}
+
+func (s *JSONSink) Flush(log []byte) error {
+	rec, err := encode.Decode(log)
+	if err != nil {
+		return err
+	}
+	return s.enc.Encode(rec)
+}
+
+func (s *JSONSink) Close() error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+// slog.Handler, that writes into logger's flusher. Logger's tags are kept, record's message is log's message,
+// attributes are fields (keys of grouped attributes are "group.key").
+// slog levels below Info are debugs, below Warn are infos, below Error are warnings
+type SlogHandler struct {
+	l      LogsWriter
+	name   string
+	fields []Field
+	prefix string
+}
+
+// name is the name of every log
+func NewSlogHandler(l LogsWriter, name string) *SlogHandler {
+	return &SlogHandler{l: l, name: name}
+}
+
+// slog.Logger, that writes into l
+func NewSlogLogger(l LogsWriter, name string) *slog.Logger {
+	return slog.New(NewSlogHandler(l, name))
+}
+
+func slogLevel(lvl slog.Level) encode.LogsFlushLevel {
+	switch {
+	case lvl < slog.LevelInfo:
+		return encode.DebugLevel
+	case lvl < slog.LevelWarn:
+		return encode.InfoLevel
+	case lvl < slog.LevelError:
+		return encode.WarningLevel
+	default:
+		return encode.ErrorLevel
+	}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	switch l := h.l.(type) {
+	case *LogsContainer:
+		return slogLevel(lvl) >= l.f.level(l.tags, &l.lvl)
+	case *PackageLogsContainer:
+		return slogLevel(lvl) >= l.f.level(l.tags, &l.lvl)
+	case nopLogger:
+		return false
+	}
+	return true
+}
+
+func (h *SlogHandler) Handle(_ context.Context, rec slog.Record) error {
+	fields := make([]Field, len(h.fields), len(h.fields)+rec.NumAttrs())
+	copy(fields, h.fields)
+	rec.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.prefix, a)
+		return true
+	})
+	switch slogLevel(rec.Level) {
+	case encode.DebugLevel:
+		h.l.Debug(h.name, rec.Message, fields...)
+	case encode.InfoLevel:
+		h.l.Info(h.name, rec.Message, fields...)
+	case encode.WarningLevel:
+		h.l.Warning(h.name, rec.Message, fields...)
+	default:
+		h.l.Error(h.name, errors.New(rec.Message), fields...)
+	}
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.fields = make([]Field, len(h.fields), len(h.fields)+len(attrs))
+	copy(nh.fields, h.fields)
+	for _, a := range attrs {
+		nh.fields = appendAttr(nh.fields, h.prefix, a)
+	}
+	return &nh
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	nh := *h
+	nh.prefix = h.prefix + name + "."
+	return &nh
+}
+
+func appendAttr(fields []Field, prefix string, a slog.Attr) []Field {
+	v := a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	key := prefix + a.Key
+	switch v.Kind() {
+	case slog.KindGroup:
+		if a.Key != "" {
+			prefix = key + "."
+		}
+		for _, ga := range v.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	case slog.KindString:
+		return append(fields, String(key, v.String()))
+	case slog.KindInt64:
+		return append(fields, Int64(key, v.Int64()))
+	case slog.KindUint64:
+		return append(fields, Int64(key, int64(v.Uint64())))
+	case slog.KindFloat64:
+		return append(fields, Float(key, v.Float64()))
+	case slog.KindDuration:
+		return append(fields, Duration(key, v.Duration()))
+	case slog.KindTime:
+		return append(fields, String(key, v.Time().Format(time.RFC3339Nano)))
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return append(fields, Err(key, err))
+		}
+		return append(fields, String(key, fmt.Sprint(v.Any())))
+	default:
+		return append(fields, String(key, v.String()))
+	}
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+func TestSlogHandler(t *testing.T) {
+	f := newQueue(FlusherOptions{QueueLength: 10})
+	f.SetLevels(encode.InfoLevel, nil)
+	sl := NewSlogLogger(f.NewLogsContainer("pkg"), "slog")
+
+	sl.Debug("filtered")
+	sl.Info("started", "file", "price.csv", "rows", 10)
+	sl.With("sup", "a").WithGroup("db").Warn("slow", "took", time.Second, slog.Group("conn", "id", uint64(3)))
+	sl.Error("failed", "err", errors.New("boom"), slog.Attr{})
+	sl.Log(context.Background(), slog.LevelWarn-1, "below warn")
+
+	want := []struct {
+		typ     encode.LogType
+		message string
+		fields  string
+	}{
+		{encode.Info, "started", ` file="price.csv" rows=10`},
+		{encode.Warning, "slow", ` sup="a" db.took=1s db.conn.id=3`},
+		{encode.Error, "failed", ` err="boom"`},
+		{encode.Info, "below warn", ""},
+	}
+	if len(f.queue) != len(want) {
+		t.Fatalf("%d logs, want %d", len(f.queue), len(want))
+	}
+	for i, w := range want {
+		rec, err := encode.Decode(f.queue[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Type != w.typ || rec.Name != "slog" || rec.Message != w.message || rec.Fields.String() != w.fields || len(rec.Tags) != 1 || rec.Tags[0] != "pkg" {
+			t.Fatalf("log %d is %s", i, rec.String())
+		}
+	}
+
+	if sl.Enabled(context.Background(), slog.LevelDebug) || !sl.Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("Enabled() doesn't follow logger's level")
+	}
+	if NewSlogLogger(nopLogger{}, "slog").Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("nop logger is enabled")
+	}
+}
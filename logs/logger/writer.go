@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+const writer_maxlinelen = 4096
+
+// io.Writer, that writes every line into logger as log of given level and name,
+// e.g. for debug output of third-party clients. Lines longer than 4096 bytes are truncated
+type LogsWriterAdapter struct {
+	l    LogsWriter
+	lvl  encode.LogsFlushLevel
+	name string
+
+	mux  sync.Mutex
+	line []byte
+	cut  bool
+}
+
+func NewWriter(l LogsWriter, lvl encode.LogsFlushLevel, name string) *LogsWriterAdapter {
+	return &LogsWriterAdapter{l: l, lvl: lvl, name: name}
+}
+
+func (w *LogsWriterAdapter) Write(p []byte) (int, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i != -1 {
+			chunk = p[:i]
+		}
+		if room := writer_maxlinelen - len(w.line); len(chunk) > room {
+			chunk, w.cut = chunk[:room], true
+		}
+		w.line = append(w.line, chunk...)
+		if i == -1 {
+			break
+		}
+		w.writeLine()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// writes unfinished line
+func (w *LogsWriterAdapter) Flush() {
+	w.mux.Lock()
+	if len(w.line) > 0 {
+		w.writeLine()
+	}
+	w.mux.Unlock()
+}
+
+// must be called with locked mux
+func (w *LogsWriterAdapter) writeLine() {
+	line := string(bytes.TrimRight(w.line, "\r"))
+	if w.cut {
+		line += "..."
+	}
+	w.line, w.cut = w.line[:0], false
+	switch w.lvl {
+	case encode.DebugLevel:
+		w.l.Debug(w.name, line)
+	case encode.InfoLevel:
+		w.l.Info(w.name, line)
+	case encode.WarningLevel:
+		w.l.Warning(w.name, line)
+	default:
+		w.l.Error(w.name, errors.New(line))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+// reads logs files (streams of frames, as logsserver stores them) and prints filtered logs
+// usage: logs [flags] file...   ("-" or no files means stdin)
+
+const follow_interval = time.Millisecond * 500
+
+var time_layouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+type filter struct {
+	lvl   encode.LogsFlushLevel
+	tag   string
+	name  string
+	since time.Time
+	until time.Time
+}
+
+func main() {
+	lvl := flag.String("lvl", "DBG", "minimal log type: DBG, INF, WRN or ERR")
+	tag := flag.String("tag", "", "only logs with this tag")
+	name := flag.String("name", "", "only logs with this name")
+	since := flag.String("since", "", "only logs since this time (local), e.g. \"2006-01-02 15:04:05\"")
+	until := flag.String("until", "", "only logs before this time (local)")
+	follow := flag.Bool("f", false, "follow the last file, waiting for new logs")
+	jsn := flag.Bool("json", false, "print newline-delimited json")
+	flag.Parse()
+
+	flt := &filter{tag: *tag, name: *name}
+	lt, ok := encode.ParseLogType(strings.ToUpper(*lvl))
+	if !ok {
+		fatal(errors.New("unknown log type: " + *lvl))
+	}
+	flt.lvl = encode.LogsFlushLevel(lt)
+	var err error
+	if flt.since, err = parseTime(*since); err != nil {
+		fatal(err)
+	}
+	if flt.until, err = parseTime(*until); err != nil {
+		fatal(err)
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var printlog func(log []byte, rec *encode.Record) error
+	if *jsn {
+		enc := json.NewEncoder(out)
+		printlog = func(_ []byte, rec *encode.Record) error {
+			return enc.Encode(rec)
+		}
+	} else {
+		printlog = func(log []byte, _ *encode.Record) error {
+			_, err := out.WriteString(encode.DecodeToString(log) + "\n")
+			return err
+		}
+	}
+
+	for i, fname := range files {
+		var r io.Reader
+		if fname == "-" {
+			r = os.Stdin
+		} else {
+			f, err := os.Open(fname)
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			r = f
+			if *follow && i == len(files)-1 {
+				r = &followReader{f: f, flush: out.Flush}
+			}
+		}
+		if err := readLogs(fname, bufio.NewReader(r), flt, printlog); err != nil {
+			out.Flush()
+			fatal(errors.New(fname + ": " + err.Error()))
+		}
+	}
+}
+
+// corrupt records are reported to stderr and skipped, frames are read further
+func readLogs(fname string, r io.Reader, flt *filter, printlog func([]byte, *encode.Record) error) error {
+	for n := 1; ; n++ {
+		log, err := encode.ReadFrame(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		rec, err := encode.Decode(log)
+		if err != nil {
+			os.Stderr.WriteString("logs: " + fname + ": record " + strconv.Itoa(n) + " skipped: " + err.Error() + "\n")
+			continue
+		}
+		if !flt.match(&rec) {
+			continue
+		}
+		if err = printlog(log, &rec); err != nil {
+			return err
+		}
+	}
+}
+
+func (flt *filter) match(rec *encode.Record) bool {
+	if encode.LogsFlushLevel(rec.Type) < flt.lvl {
+		return false
+	}
+	if flt.name != "" && rec.Name != flt.name {
+		return false
+	}
+	if !flt.since.IsZero() && rec.Time.Before(flt.since) {
+		return false
+	}
+	if !flt.until.IsZero() && !rec.Time.Before(flt.until) {
+		return false
+	}
+	if flt.tag != "" {
+		for _, t := range rec.Tags {
+			if t == flt.tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// never returns io.EOF, waits for file to grow instead
+type followReader struct {
+	f     *os.File
+	flush func() error
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || (err != nil && !errors.Is(err, io.EOF)) {
+			return n, err
+		}
+		r.flush()
+		time.Sleep(follow_interval)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range time_layouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unknown time format: " + s)
+}
+
+func fatal(err error) {
+	os.Stderr.WriteString("logs: " + err.Error() + "\n")
+	os.Exit(1)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/encode"
+)
+
+func TestReadLogsSkipsCorrupt(t *testing.T) {
+	good := encode.EncodeLog(encode.Info, time.Now(), nil, "n", "msg")
+	corrupt := append([]byte{}, good...)
+	corrupt[11] = 'x' // tags don't start with "["
+	var stream []byte
+	for _, log := range [][]byte{good, corrupt, good} {
+		stream = encode.AppendFrame(stream, log)
+	}
+	var printed int
+	err := readLogs("test", bytes.NewReader(stream), &filter{}, func(log []byte, rec *encode.Record) error {
+		printed++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed != 2 {
+		t.Fatalf("printed %d logs, want 2", printed)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/okonma-violet/confdecoder"
+	"github.com/okonma-violet/spec/logs/encode"
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+type config struct {
+	Network  string
+	Addr     string
+	LogsPath string
+}
+
+// stored logs files are streams of frames, one file per day
+const logsfile_layout = "20060102"
+const logsfile_prefix = "logs_"
+const logsfile_suffix = ".bin"
+
+const frameschanlen = 64
+
+func main() {
+	conf := &config{}
+	err := confdecoder.DecodeFile("config.txt", conf)
+	if err != nil {
+		panic("read config file err: " + err.Error())
+	}
+	if conf.Addr == "" {
+		panic("no Addr specified in config.txt")
+	}
+	if conf.LogsPath == "" {
+		panic("no LogsPath specified in config.txt")
+	}
+	if conf.Network == "" {
+		conf.Network = "tcp"
+	}
+	if conf.Network != "tcp" && conf.Network != "unix" {
+		panic("unsupported Network in config.txt, must be tcp or unix")
+	}
+	conf.LogsPath += "/"
+
+	ctx, _ := createContextWithInterruptSignal()
+
+	flsh := logger.NewFlusher(encode.DebugLevel)
+	l := flsh.NewLogsContainer("logsserver")
+
+	if conf.Network == "unix" {
+		os.Remove(conf.Addr)
+	}
+	ln, err := net.Listen(conf.Network, conf.Addr)
+	if err != nil {
+		panic("listen err: " + err.Error())
+	}
+	l.Info("Listen", "listening on "+conf.Network+":"+conf.Addr)
+
+	frames := make(chan []byte, frameschanlen)
+	writerdone := make(chan struct{})
+	go func() {
+		storeWorker(l.NewSubLogger("Store"), conf.LogsPath, frames)
+		close(writerdone)
+	}()
+
+	conns := &sync.WaitGroup{}
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				l.Error("Accept", err)
+				continue
+			}
+			conns.Add(1)
+			go func() {
+				serveConn(ctx, l.NewSubLogger("Conn", conn.RemoteAddr().String()), conn, frames)
+				conns.Done()
+			}()
+		}
+	}()
+
+	<-ctx.Done()
+	l.Debug("Context", "done, exiting")
+	ln.Close()
+	<-accepting
+	conns.Wait()
+	close(frames)
+	<-writerdone
+	flsh.Close()
+	flsh.DoneWithTimeout(time.Second * 5)
+}
+
+func serveConn(ctx context.Context, l logger.Logger, conn net.Conn, frames chan<- []byte) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	l.Debug("Serve", "connected")
+	r := bufio.NewReader(conn)
+	for {
+		log, err := encode.ReadFrame(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				l.Debug("Serve", "disconnected")
+			} else {
+				l.Error("ReadFrame", err)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		frames <- log
+	}
+}
+
+func storeWorker(l logger.Logger, logspath string, frames <-chan []byte) {
+	var file *os.File
+	var w *bufio.Writer
+	var day string
+	flushticker := time.NewTicker(time.Second)
+	defer flushticker.Stop()
+	defer func() {
+		if file != nil {
+			w.Flush()
+			file.Close()
+		}
+	}()
+
+	for {
+		select {
+		case log, ok := <-frames:
+			if !ok {
+				return
+			}
+			if d := encode.GetLogTime(log).Format(logsfile_layout); d != day || file == nil {
+				if file != nil {
+					w.Flush()
+					file.Close()
+				}
+				var err error
+				if file, err = os.OpenFile(logspath+logsfile_prefix+d+logsfile_suffix, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+					l.Error("OpenFile", err)
+					file = nil
+					continue
+				}
+				w, day = bufio.NewWriter(file), d
+			}
+			if err := encode.WriteFrame(w, log); err != nil {
+				l.Error("WriteFrame", err)
+			}
+		case <-flushticker.C:
+			if file != nil {
+				if err := w.Flush(); err != nil {
+					l.Error("Flush", err)
+				}
+			}
+		}
+	}
+}
+
+func createContextWithInterruptSignal() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+	return ctx, cancel
+}
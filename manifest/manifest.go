@@ -0,0 +1,317 @@
+package manifest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/okonma-violet/spec/locker"
+)
+
+// provenance of files passing through pipeline. Every incoming attachment gets an entry with id,
+// stages append transitions to it. Entry is found by name of the file, that currently represents it
+// (attachment, extracted files, formatted csv), so stages need nothing but file names.
+// Store is a dir with entries/<id>.json and index.json (current file name -> id), dir is locked
+// on every change, so separate binaries may share it. nil *Manifest does nothing
+
+type State string
+
+const (
+	Received  State = "received"
+	Extracted State = "extracted"
+	Converted State = "converted"
+	Formatted State = "formatted"
+	Uploaded  State = "uploaded"
+	Failed    State = "failed"
+	Requeued  State = "requeued"
+)
+
+// file in final state doesn't represent entry anymore
+func (s State) Final() bool {
+	return s == Uploaded || s == Failed
+}
+
+// where attachment came from
+type Source struct {
+	Account    string `json:",omitempty"`
+	Mailbox    string
+	From       string
+	Subject    string
+	Date       time.Time
+	Attachment string
+	Rule       string `json:",omitempty"` // that matched message with supplier
+}
+
+type Transition struct {
+	Time   time.Time
+	State  State
+	Stage  string
+	Files  []string // files, that represent entry after transition
+	Reason string   `json:",omitempty"`
+}
+
+type Entry struct {
+	ID          string
+	Supplier    string
+	Source      Source
+	State       State
+	Files       []string
+	Transitions []Transition
+}
+
+type Manifest struct {
+	dir string
+	mux sync.Mutex // dir lock is per process, so goroutines must wait here
+}
+
+var ErrUnknownFile = errors.New("file is not in manifest")
+
+const indexfilename = "index.json"
+const entriesdir = "entries"
+
+var lockopts = &locker.Options{Timeout: time.Second * 10, MinBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 200}
+
+func Open(dirpath string) (*Manifest, error) {
+	if err := os.MkdirAll(filepath.Join(dirpath, entriesdir), 0755); err != nil {
+		return nil, err
+	}
+	return &Manifest{dir: dirpath}, nil
+}
+
+// creates entry for just received file, returns its id.
+// Entry, that was represented by the same file name, is failed as overwritten
+func (m *Manifest) Receive(ctx context.Context, file, stage, supplier string, src Source) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	err = m.update(ctx, func(index map[string]string) error {
+		e := &Entry{ID: id, Supplier: supplier, Source: src}
+		m.transit(index, e, Transition{Time: time.Now(), State: Received, Stage: stage, Files: []string{file}})
+		return m.writeEntry(e)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// appends transition to entry of file. File is replaced with newfiles in entry's files,
+// with no newfiles given entry is still represented by file (or is not anymore, if state is final).
+// Files, that are not in manifest (e.g. were put into dir by hand), are skipped
+func (m *Manifest) Transit(ctx context.Context, file string, state State, stage string, newfiles ...string) error {
+	if m == nil {
+		return nil
+	}
+	if len(newfiles) == 0 && !state.Final() {
+		newfiles = []string{file}
+	}
+	return m.update(ctx, func(index map[string]string) error {
+		return ignoreUnknown(m.transitFile(index, file, Transition{Time: time.Now(), State: state, Stage: stage}, newfiles))
+	})
+}
+
+// appends failed transition with reason to entry of file, file is removed from entry's files.
+// Files, that are not in manifest, are skipped
+func (m *Manifest) Fail(ctx context.Context, file string, stage string, reason string) error {
+	if m == nil {
+		return nil
+	}
+	return m.update(ctx, func(index map[string]string) error {
+		return ignoreUnknown(m.transitFile(index, file, Transition{Time: time.Now(), State: Failed, Stage: stage, Reason: reason}, nil))
+	})
+}
+
+func ignoreUnknown(err error) error {
+	if errors.Is(err, ErrUnknownFile) {
+		return nil
+	}
+	return err
+}
+
+// returns file to entry with id, e.g. when failed file was fixed and put back. Empty id is skipped
+func (m *Manifest) Requeue(ctx context.Context, id, file, stage string) error {
+	if m == nil || id == "" {
+		return nil
+	}
+	return m.update(ctx, func(index map[string]string) error {
+		e, err := m.readEntry(id)
+		if err != nil {
+			return err
+		}
+		files := append(append(make([]string, 0, len(e.Files)+1), e.Files...), file)
+		m.transit(index, e, Transition{Time: time.Now(), State: Requeued, Stage: stage, Files: files})
+		return m.writeEntry(e)
+	})
+}
+
+// id of entry, currently represented by file. Returns ErrUnknownFile if file is not in manifest
+func (m *Manifest) ID(file string) (string, error) {
+	if m == nil {
+		return "", ErrUnknownFile
+	}
+	index, err := m.readIndex()
+	if err != nil {
+		return "", err
+	}
+	id, ok := index[file]
+	if !ok {
+		return "", ErrUnknownFile
+	}
+	return id, nil
+}
+
+func (m *Manifest) Get(id string) (*Entry, error) {
+	if m == nil {
+		return nil, ErrUnknownFile
+	}
+	return m.readEntry(id)
+}
+
+// must be called with index, locked by update
+func (m *Manifest) transitFile(index map[string]string, file string, t Transition, newfiles []string) error {
+	id, ok := index[file]
+	if !ok {
+		return ErrUnknownFile
+	}
+	e, err := m.readEntry(id)
+	if err != nil {
+		return err
+	}
+	// file may be only one of entry's files (e.g. one of unzipped), others are kept
+	t.Files = make([]string, 0, len(e.Files)+len(newfiles))
+	for _, f := range e.Files {
+		if f != file {
+			t.Files = append(t.Files, f)
+		}
+	}
+	t.Files = append(t.Files, newfiles...)
+	m.transit(index, e, t)
+	return m.writeEntry(e)
+}
+
+// entry is represented by transition's files after it. Entries, that were represented by the same
+// file names, are failed as overwritten. Entry without files is done and is not in index anymore
+func (m *Manifest) transit(index map[string]string, e *Entry, t Transition) {
+	for _, f := range e.Files {
+		if index[f] == e.ID {
+			delete(index, f)
+		}
+	}
+	e.Transitions = append(e.Transitions, t)
+	e.State = t.State
+	e.Files = t.Files
+	for _, f := range e.Files {
+		if previd, ok := index[f]; ok && previd != e.ID {
+			m.transitFile(index, f, Transition{Time: t.Time, State: Failed, Stage: t.Stage, Reason: "file " + f + " was overwritten by entry " + e.ID}, nil)
+		}
+		index[f] = e.ID
+	}
+}
+
+func (m *Manifest) update(ctx context.Context, f func(index map[string]string) error) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	lease, err := locker.LockDirLease(ctx, m.dir, lockopts)
+	if err != nil {
+		return err
+	}
+	defer lease.Unlock()
+
+	index, err := m.readIndex()
+	if err != nil {
+		return err
+	}
+	if err = f(index); err != nil {
+		return err
+	}
+	if err = lease.Check(); err != nil {
+		return err
+	}
+	return m.writeIndex(index)
+}
+
+func (m *Manifest) readIndex() (map[string]string, error) {
+	index := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(m.dir, indexfilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return index, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, errors.New("decode " + indexfilename + " err: " + err.Error())
+	}
+	return index, nil
+}
+
+func (m *Manifest) writeIndex(index map[string]string) error {
+	data, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.dir, indexfilename), data)
+}
+
+func (m *Manifest) readEntry(id string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(m.dir, entriesdir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err = json.Unmarshal(data, e); err != nil {
+		return nil, errors.New("decode entry " + id + " err: " + err.Error())
+	}
+	return e, nil
+}
+
+func (m *Manifest) writeEntry(e *Entry) error {
+	data, err := json.MarshalIndent(e, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.dir, entriesdir, e.ID+".json"), data)
+}
+
+// readers never see half-written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sortable by receiving time
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,164 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func states(e *Entry) string {
+	var s string
+	for _, t := range e.Transitions {
+		s += string(t.State) + " "
+	}
+	return strings.TrimSpace(s)
+}
+
+func get(t *testing.T, m *Manifest, id string) *Entry {
+	t.Helper()
+	e, err := m.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestTransitions(t *testing.T) {
+	ctx := context.Background()
+	m, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Receive(ctx, "price.zip", "emailer", "sup", Source{Mailbox: "INBOX", Attachment: "price.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// zip is replaced with extracted files, each of them goes further on its own
+	if err = m.Transit(ctx, "price.zip", Extracted, "unzipper", "a.xls", "b.xls"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.ID("price.zip"); !errors.Is(err, ErrUnknownFile) {
+		t.Fatalf("ID() of replaced file err = %v, want ErrUnknownFile", err)
+	}
+	if err = m.Transit(ctx, "a.xls", Converted, "unzipper", "a.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Transit(ctx, "a.csv", Formatted, "csvformatter"); err != nil {
+		t.Fatal(err)
+	}
+	e := get(t, m, id)
+	if e.State != Formatted || strings.Join(e.Files, " ") != "b.xls a.csv" || states(e) != "received extracted converted formatted" {
+		t.Fatalf("entry state %s, files %v, transitions %s", e.State, e.Files, states(e))
+	}
+	for _, f := range e.Files {
+		if fid, err := m.ID(f); err != nil || fid != id {
+			t.Fatalf("ID(%s) = %s, %v, want %s", f, fid, err, id)
+		}
+	}
+	// final state
+	if err = m.Transit(ctx, "a.csv", Uploaded, "data2db"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fail(ctx, "b.xls", "unzipper", "broken"); err != nil {
+		t.Fatal(err)
+	}
+	e = get(t, m, id)
+	if e.State != Failed || len(e.Files) != 0 || e.Transitions[len(e.Transitions)-1].Reason != "broken" {
+		t.Fatalf("entry %+v, want failed without files", e)
+	}
+	for _, f := range []string{"a.csv", "b.xls"} {
+		if _, err = m.ID(f); !errors.Is(err, ErrUnknownFile) {
+			t.Fatalf("ID(%s) of done entry err = %v", f, err)
+		}
+	}
+	// files, that are not in manifest, are skipped
+	if err = m.Transit(ctx, "unknown.csv", Formatted, "csvformatter"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fail(ctx, "unknown.csv", "csvformatter", "bad"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	ctx := context.Background()
+	m, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := m.Receive(ctx, "price.csv", "emailer", "sup", Source{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.Receive(ctx, "other.zip", "emailer", "sup", Source{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Receive(ctx, "price.csv", "emailer", "sup", Source{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := get(t, m, first); e.State != Failed || !strings.Contains(e.Transitions[len(e.Transitions)-1].Reason, second) {
+		t.Fatalf("overwritten entry %+v, want failed by %s", e, second)
+	}
+	// extracted file overwrites csv too
+	if err = m.Transit(ctx, "other.zip", Extracted, "unzipper", "price.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if e := get(t, m, second); e.State != Failed {
+		t.Fatalf("overwritten entry %+v, want failed", e)
+	}
+	if id, err := m.ID("price.csv"); err != nil || id != other {
+		t.Fatalf("ID() = %s, %v, want %s", id, err, other)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	ctx := context.Background()
+	m, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Receive(ctx, "price.csv", "emailer", "sup", Source{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Fail(ctx, "price.csv", "csvformatter", "bad row"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Requeue(ctx, id, "price.csv", "csvformatter"); err != nil {
+		t.Fatal(err)
+	}
+	e := get(t, m, id)
+	if e.State != Requeued || strings.Join(e.Files, " ") != "price.csv" || states(e) != "received failed requeued" {
+		t.Fatalf("entry state %s, files %v, transitions %s", e.State, e.Files, states(e))
+	}
+	if fid, err := m.ID("price.csv"); err != nil || fid != id {
+		t.Fatalf("ID() = %s, %v, want %s", fid, err, id)
+	}
+	// empty id is skipped
+	if err = m.Requeue(ctx, "", "price.csv", "csvformatter"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNilManifest(t *testing.T) {
+	ctx := context.Background()
+	var m *Manifest
+	if id, err := m.Receive(ctx, "price.csv", "emailer", "sup", Source{}); id != "" || err != nil {
+		t.Fatalf("Receive() = %q, %v", id, err)
+	}
+	if err := m.Transit(ctx, "price.csv", Formatted, "csvformatter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Fail(ctx, "price.csv", "csvformatter", "bad"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Requeue(ctx, "id", "price.csv", "csvformatter"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ID("price.csv"); !errors.Is(err, ErrUnknownFile) {
+		t.Fatalf("ID() err = %v, want ErrUnknownFile", err)
+	}
+}
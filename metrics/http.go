@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const shutdown_timeout = time.Second * 5
+
+// serves Default registry in Prometheus text format
+func Handler() http.Handler {
+	return Default
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(r.Text()))
+}
+
+// serves Handler on addr's /metrics until ctx is done
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), shutdown_timeout)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
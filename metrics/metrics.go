@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metrics with labels. Series are created on first use with label values, that must be passed
+// in the same order and number as labels were passed on creation
+type Registry struct {
+	mux     sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(b *strings.Builder)
+}
+
+// registry of all New* metrics, exposed by Handler
+var Default = NewRegistry()
+
+var ErrDuplicate = errors.New("metric with this name is already registered")
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(ErrDuplicate.Error() + ": " + name + " (lib metrics)")
+	}
+	r.metrics[name] = m
+}
+
+// Prometheus text format, metrics are sorted by name
+func (r *Registry) Text() string {
+	r.mux.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ms := make([]metric, 0, len(names))
+	for _, name := range names {
+		ms = append(ms, r.metrics[name])
+	}
+	r.mux.Unlock()
+
+	var b strings.Builder
+	for _, m := range ms {
+		m.write(&b)
+	}
+	return b.String()
+}
+
+// common part of all metrics
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mux    sync.Mutex
+	series map[string]interface{} // key is joined label values
+	keys   []string               // sorted
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{name: name, help: help, typ: typ, labels: labels, series: make(map[string]interface{})}
+}
+
+// must be called with locked mux. newseries is called if there is no such series yet
+func (v *vec) get(labelvalues []string, newseries func() interface{}) interface{} {
+	if len(labelvalues) != len(v.labels) {
+		panic("metric " + v.name + " has " + strconv.Itoa(len(v.labels)) + " labels, got " + strconv.Itoa(len(labelvalues)) + " values (lib metrics)")
+	}
+	key := strings.Join(labelvalues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = newseries()
+		v.series[key] = s
+		i := sort.SearchStrings(v.keys, key)
+		v.keys = append(v.keys, "")
+		copy(v.keys[i+1:], v.keys[i:])
+		v.keys[i] = key
+	}
+	return s
+}
+
+func (v *vec) writeHeader(b *strings.Builder) {
+	b.WriteString("# HELP " + v.name + " " + escape(v.help, false) + "\n")
+	b.WriteString("# TYPE " + v.name + " " + v.typ + "\n")
+}
+
+// {label="value",...}, extra label is appended if not empty
+func (v *vec) labelsString(key string, extraname, extravalue string) string {
+	if len(v.labels) == 0 && extraname == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	if len(v.labels) > 0 {
+		for i, lv := range strings.Split(key, "\xff") {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(v.labels[i] + "=\"" + escape(lv, true) + "\"")
+		}
+	}
+	if extraname != "" {
+		if len(v.labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraname + "=\"" + extravalue + "\"")
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var helpescaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+var labelescaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+
+func escape(s string, quotes bool) string {
+	if quotes {
+		return labelescaper.Replace(s)
+	}
+	return helpescaper.Replace(s)
+}
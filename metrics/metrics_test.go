@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("files_total", "Files\nprocessed", "stage", "supplier")
+	g := r.NewGauge("queue_length", "Queued files")
+	h := r.NewHistogram("upload_seconds", "Upload duration", []float64{1, 0.5}, "supplier")
+
+	c.Inc("format", "b")
+	c.Add(2, "format", `a "quoted"`)
+	c.Add(-1, "format", "b") // ignored
+	g.Set(5)
+	g.Add(-2)
+	h.Observe(0.2, "a")
+	h.Observe(1, "a")
+	h.Observe(3, "a")
+
+	want := `# HELP files_total Files\nprocessed
+# TYPE files_total counter
+files_total{stage="format",supplier="a \"quoted\""} 2
+files_total{stage="format",supplier="b"} 1
+# HELP queue_length Queued files
+# TYPE queue_length gauge
+queue_length 3
+# HELP upload_seconds Upload duration
+# TYPE upload_seconds histogram
+upload_seconds_bucket{supplier="a",le="0.5"} 1
+upload_seconds_bucket{supplier="a",le="1"} 2
+upload_seconds_bucket{supplier="a",le="+Inf"} 3
+upload_seconds_sum{supplier="a"} 4.2
+upload_seconds_count{supplier="a"} 3
+`
+	if got := r.Text(); got != want {
+		t.Fatalf("Text() =\n%s\nwant\n%s", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if string(body) != want || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("served %q with content type %q", body, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(r *Registry, c *Counter)
+	}{
+		{"duplicate", func(r *Registry, c *Counter) { r.NewGauge("files_total", "Files") }},
+		{"less label values", func(r *Registry, c *Counter) { c.Inc() }},
+		{"more label values", func(r *Registry, c *Counter) { c.Inc("format", "a") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			c := r.NewCounter("files_total", "Files", "stage")
+			defer func() {
+				if recover() == nil {
+					t.Fatal("no panic")
+				}
+			}()
+			tt.f(r, c)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package metrics
+
+// metrics of pipeline's binaries, stage is binary's name
+
+var JobDuration = NewHistogram("pipeline_job_duration_seconds", "Duration of stage's job.", nil, "stage")
+
+var LockWait = NewHistogram("pipeline_lock_wait_seconds", "Time spent waiting for dirs locks.", nil, "stage")
+
+var FilesProcessed = NewCounter("pipeline_files_processed_total", "Files processed by stage.", "stage", "supplier")
+
+var RowsRead = NewCounter("pipeline_rows_read_total", "Csv rows read by stage.", "stage", "supplier")
+
+var RowsRejected = NewCounter("pipeline_rows_rejected_total", "Csv rows rejected by stage.", "stage", "supplier", "reason")
+
+var MessagesFetched = NewCounter("pipeline_imap_messages_fetched_total", "IMAP messages fetched by emailer.", "account", "mailbox")
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// only grows
+type Counter struct {
+	vec
+}
+
+// can go up and down
+type Gauge struct {
+	vec
+}
+
+// counts observations in buckets (upper bounds, +Inf bucket is added)
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+type histogramseries struct {
+	counts []uint64 // not cumulative, last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// seconds, from 5ms to 10min
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// nil buckets are DefBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	bs := make([]float64, len(buckets))
+	copy(bs, buckets)
+	sort.Float64s(bs)
+	h := &Histogram{vec: newVec(name, help, "histogram", labels), buckets: bs}
+	r.register(name, h)
+	return h
+}
+
+func newFloat() interface{} {
+	return new(float64)
+}
+
+// negative v is ignored
+func (c *Counter) Add(v float64, labelvalues ...string) {
+	if v < 0 {
+		return
+	}
+	c.mux.Lock()
+	*c.get(labelvalues, newFloat).(*float64) += v
+	c.mux.Unlock()
+}
+
+func (c *Counter) Inc(labelvalues ...string) {
+	c.Add(1, labelvalues...)
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.writeHeader(b)
+	for _, key := range c.keys {
+		b.WriteString(c.name + c.labelsString(key, "", "") + " " + formatFloat(*c.series[key].(*float64)) + "\n")
+	}
+}
+
+func (g *Gauge) Set(v float64, labelvalues ...string) {
+	g.mux.Lock()
+	*g.get(labelvalues, newFloat).(*float64) = v
+	g.mux.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelvalues ...string) {
+	g.mux.Lock()
+	*g.get(labelvalues, newFloat).(*float64) += v
+	g.mux.Unlock()
+}
+
+func (g *Gauge) write(b *strings.Builder) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.writeHeader(b)
+	for _, key := range g.keys {
+		b.WriteString(g.name + g.labelsString(key, "", "") + " " + formatFloat(*g.series[key].(*float64)) + "\n")
+	}
+}
+
+func (h *Histogram) Observe(v float64, labelvalues ...string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	s := h.get(labelvalues, func() interface{} {
+		return &histogramseries{counts: make([]uint64, len(h.buckets)+1)}
+	}).(*histogramseries)
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+// observes seconds since start
+func (h *Histogram) Since(start time.Time, labelvalues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelvalues...)
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.writeHeader(b)
+	for _, key := range h.keys {
+		s := h.series[key].(*histogramseries)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			b.WriteString(h.name + "_bucket" + h.labelsString(key, "le", formatFloat(le)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		b.WriteString(h.name + "_bucket" + h.labelsString(key, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		b.WriteString(h.name + "_sum" + h.labelsString(key, "", "") + " " + formatFloat(s.sum) + "\n")
+		b.WriteString(h.name + "_count" + h.labelsString(key, "", "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
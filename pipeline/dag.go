@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/okonma-violet/spec/logs/logger"
+)
+
+// stage runs its job when triggered by upstream stage, or on its timer (if interval is not zero).
+// Downstream stages are triggered when job processed any files, or when stage itself was triggered,
+// so files, passed through stage untouched, are not left waiting for timers
+type stage struct {
+	name     string
+	job      func(ctx context.Context, l logger.Logger) int
+	interval time.Duration
+	next     []*stage
+	trigger  chan struct{}
+}
+
+func newStage(name string, interval time.Duration, job func(ctx context.Context, l logger.Logger) int) *stage {
+	return &stage{name: name, job: job, interval: interval, trigger: make(chan struct{}, 1)}
+}
+
+// returns the last of next stages, for chaining
+func (s *stage) then(next ...*stage) *stage {
+	s.next = append(s.next, next...)
+	return next[len(next)-1]
+}
+
+func (s *stage) notify() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// triggers stage on events (e.g. of watcher reporting ready files), until ctx is done
+func (s *stage) watch(ctx context.Context, events <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+				s.notify()
+			}
+		}
+	}()
+}
+
+func (s *stage) run(ctx context.Context, l logger.Logger, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	l.Info("Routine", "stage started")
+	for {
+		var triggered bool
+		select {
+		case <-ctx.Done():
+			l.Info("Routine", "context done, exiting loop")
+			return
+		case <-s.trigger:
+			triggered = true
+		case <-tick:
+		}
+		l.Debug("Job", "started")
+		n := s.job(ctx, l)
+		l.Debug("Job", "done", logger.Int("processed", n))
+		if n > 0 || triggered {
+			for _, nx := range s.next {
+				nx.notify()
+			}
+		}
+	}
+}
+
+// runs all stages reachable from root, root's first job is started immediately
+func runDAG(ctx context.Context, l logger.Logger, root *stage) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	seen := make(map[*stage]bool)
+	var start func(s *stage)
+	start = func(s *stage) {
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		wg.Add(1)
+		go s.run(ctx, l.NewSubLogger(s.name), wg)
+		for _, nx := range s.next {
+			start(nx)
+		}
+	}
+	start(root)
+	root.notify()
+	return wg
+}
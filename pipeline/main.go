@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/okonma-violet/spec/config"
+	"github.com/okonma-violet/spec/csvformatter/format"
+	"github.com/okonma-violet/spec/data2db/store"
+	"github.com/okonma-violet/spec/emailer/fetch"
+	"github.com/okonma-violet/spec/logs/encode"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+	"github.com/okonma-violet/spec/quarantine"
+	"github.com/okonma-violet/spec/unzipper/extract"
+	"github.com/okonma-violet/spec/watcher"
+)
+
+// runs emailer, unzipper, csvformatter and data2db stages in one process:
+// fetch -> extract -> convert -> format -> upload -> categorize.
+// Stages still pass files through dirs and lock them, so standalone binaries may run alongside.
+// emailer's mail cursors dir is in working dir.
+// Input dirs of extract, format and upload are watched with inotify, so files, written there by
+// someone else, are picked up once ready. If watching fails, these stages rely on timers
+// With -i mailboxes are watched with IMAP IDLE, attachments saved by them trigger extract
+
+func main() {
+	conf, common := &config.Pipeline{}, &config.Common{}
+	err := config.Load("config.txt", "PIPELINE", conf, common)
+	if err != nil {
+		panic(err.Error())
+	}
+	accs, err := config.LoadImapAccounts(conf.ImapAccountsPath, "PIPELINE")
+	if err != nil {
+		panic(err.Error())
+	}
+	csvformat, err := config.LoadCsvFormat(conf.SuppliersCsvFormatFilePath)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	rp := flag.Bool("r", false, "remove processed files")
+	idl := flag.Bool("i", false, "hold IMAP IDLE connections, fetch timer is a fallback for mailboxes that are not idling")
+	flag.Parse()
+
+	ctx, _ := createContextWithInterruptSignal()
+
+	sinks, err := common.LogsSinks()
+	if err != nil {
+		panic(err.Error())
+	}
+	flsh := logger.NewFlusherWithOptions(logger.FlusherOptions{ConsoleLevel: encode.DebugLevel, QueueLength: 1024, Overflow: logger.DropLowest}, sinks...)
+	if common.LogsLevelsPath != "" {
+		if err := flsh.ReloadLevelsOnSighup(common.LogsLevelsPath); err != nil {
+			panic("load logs levels err: " + err.Error())
+		}
+	}
+	l := flsh.NewLogsContainer("pipeline")
+	if common.MetricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, common.MetricsAddr); err != nil {
+				l.Error("metrics.Serve", err)
+			}
+		}()
+	}
+	if *rp {
+		l.Info("Flag", "removing processed files enabled")
+	}
+
+	ec := &extract.Config{ZipPath: conf.DownloadsPath, CsvPath: conf.RawCsvPath, RemoveProcessed: *rp}
+	for i := 0; i < len(conf.ShittyCharsetZipNamesPrefixes); i++ {
+		ec.ShittyCharsets = append(ec.ShittyCharsets, extract.ShittyCharsetZip{Prefix: strings.ToLower(conf.ShittyCharsetZipNamesPrefixes[i]), Charset: conf.ShittyCharsets[i]})
+	}
+	fc := &format.Config{RawCsvPath: conf.RawCsvPath, CsvPath: conf.ProductsCsvPath, SuppliersCsvFormat: csvformat, RemoveProcessed: *rp}
+	uc := &store.Config{ProductsCsvPath: conf.ProductsCsvPath, AlternativeArticulsFilePath: conf.AlternativeArticulsFilePath, SuppliersCsvFormat: csvformat, RemoveProcessed: *rp}
+	var mf *manifest.Manifest
+	if common.ManifestPath != "" {
+		if mf, err = manifest.Open(common.ManifestPath); err != nil {
+			panic("open manifest err: " + err.Error())
+		}
+		ec.Manifest, fc.Manifest, uc.Manifest = mf, mf, mf
+	}
+	ec.QuarantinePath, fc.QuarantinePath, uc.QuarantinePath = common.QuarantinePath, common.QuarantinePath, common.QuarantinePath
+	if flag.Arg(0) == "requeue" {
+		if common.QuarantinePath == "" {
+			panic("no QuarantinePath specified in config.txt")
+		}
+		requeued, err := quarantine.Requeue(ctx, mf, common.QuarantinePath, flag.Args()[1:]...)
+		for _, r := range requeued {
+			fmt.Println("requeued " + r.File + " into " + r.SourceDir)
+		}
+		if err != nil {
+			panic("requeue err: " + err.Error())
+		}
+		return
+	}
+
+	rep := store.NewRepo(true)
+	if err = rep.OpenDBRepository(logger.WithContext(ctx, l), conf.DSN); err != nil {
+		panic(err)
+	}
+	defer rep.Close()
+	if err = rep.Upgrade(logger.WithContext(ctx, l)); err != nil {
+		panic(err)
+	}
+	// upload and categorize share db connection
+	var repmux sync.Mutex
+
+	interval := time.Second * time.Duration(conf.TimerSeconds)
+
+	extractst := newStage("extract", interval, ec.Extract)
+	formatst := newStage("format", interval, func(ctx context.Context, l logger.Logger) int {
+		sups, err := config.LoadSuppliers(conf.SuppliersConfsPath)
+		if err != nil {
+			l.Error("LoadSuppliers", err)
+			l.Error("Job", errors.New("cant do without suppliers"))
+			return 0
+		}
+		return fc.Format(ctx, l, sups)
+	})
+	uploadst := newStage("upload", interval, func(ctx context.Context, l logger.Logger) int {
+		repmux.Lock()
+		defer repmux.Unlock()
+		if err := rep.Ping(ctx); err != nil {
+			l.Error("DB.Ping", err)
+			l.Debug("DB", "reconnecting")
+			if err = rep.OpenDBRepository(logger.WithContext(ctx, l), conf.DSN); err != nil {
+				l.Error("OpenDBRepository", err)
+				l.Debug("Job", "cant work without db connection, sleeping")
+				return 0
+			}
+		}
+		return uc.Upload(logger.WithContext(ctx, l), rep)
+	})
+
+	for _, wd := range []struct {
+		path    string
+		st      *stage
+		watcher **watcher.Watcher
+	}{{conf.DownloadsPath, extractst, &ec.Watcher}, {conf.RawCsvPath, formatst, &fc.Watcher}, {conf.ProductsCsvPath, uploadst, &uc.Watcher}} {
+		w, err := watcher.New(wd.path, watcher.DefaultQuiescence)
+		if err != nil {
+			l.Error("Watcher", errors.New("watch "+wd.path+" err: "+err.Error()+", stage "+wd.st.name+" relies on timer"))
+			continue
+		}
+		defer w.Close()
+		*wd.watcher = w
+		wd.st.watch(ctx, w.Events())
+	}
+
+	var idling *fetch.Idling
+	if *idl {
+		l.Info("Flag", "IMAP IDLE enabled")
+		idling = fetch.Idle(ctx, l.NewSubLogger("fetch"), accs, conf.DownloadsPath, func() (config.Suppliers, error) {
+			return config.LoadSuppliers(conf.SuppliersConfsPath)
+		}, mf)
+		extractst.watch(ctx, idling.Saved())
+	}
+
+	fetchst := newStage("fetch", interval, func(ctx context.Context, l logger.Logger) int {
+		sups, err := config.LoadSuppliers(conf.SuppliersConfsPath)
+		if err != nil {
+			l.Error("LoadSuppliers", err)
+			return 0
+		}
+		if idling != nil {
+			return idling.Fetch(ctx, l, conf.DownloadsPath, sups, mf)
+		}
+		return fetch.Fetch(ctx, l, accs, conf.DownloadsPath, sups, mf)
+	})
+	fetchst.
+		then(extractst).
+		then(newStage("convert", interval, ec.Convert)).
+		then(formatst).
+		then(uploadst).
+		then(newStage("categorize", 0, func(ctx context.Context, l logger.Logger) int {
+			repmux.Lock()
+			defer repmux.Unlock()
+			if err := rep.Categorize(logger.WithContext(ctx, l)); err != nil {
+				l.Error("Categorize", err)
+			}
+			return 0
+		}))
+
+	wg := runDAG(ctx, l, fetchst)
+
+	<-ctx.Done()
+	l.Debug("Context", "done, waiting for stages")
+	wg.Wait()
+	flsh.Close()
+	flsh.DoneWithTimeout(time.Second * 5)
+}
+
+func createContextWithInterruptSignal() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,189 @@
+package quarantine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/manifest"
+)
+
+// files, that failed a stage, are moved into stage's dir in quarantine path, so they are not retried
+// on every tick. Quarantined file gets unique suffix, so files with the same name don't replace each other.
+// Report is saved next to file, requeue moves fixed file back to dir it came from
+
+type Report struct {
+	File       string
+	Name       string // in quarantine, file with unique suffix
+	Stage      string
+	Reason     string
+	SourceDir  string // absolute
+	ManifestID string `json:",omitempty"`
+	Time       time.Time
+}
+
+const reportsuffix = ".report.json"
+
+var ErrExists = errors.New("file with the same name is in source dir")
+
+// stage's quarantine dir
+func StageDir(basepath, stage string) string {
+	return filepath.Join(basepath, stage)
+}
+
+// moves file from srcdir into stage's dir in basepath with report, then fails file's entry in mf (if not nil).
+// With empty basepath file is left in srcdir
+func Put(ctx context.Context, mf *manifest.Manifest, basepath, srcdir, file, stage, reason string) error {
+	id, err := mf.ID(file)
+	if err != nil && !errors.Is(err, manifest.ErrUnknownFile) {
+		return err
+	}
+	if basepath != "" {
+		if err = move(basepath, srcdir, file, stage, reason, id); err != nil {
+			return err
+		}
+	}
+	return mf.Fail(ctx, file, stage, reason)
+}
+
+func move(basepath, srcdir, file, stage, reason, id string) error {
+	absdir, err := filepath.Abs(srcdir)
+	if err != nil {
+		return err
+	}
+	qdir := StageDir(basepath, stage)
+	if err = os.MkdirAll(qdir, 0755); err != nil {
+		return err
+	}
+	suffix, err := uniqueSuffix()
+	if err != nil {
+		return err
+	}
+	r := &Report{File: file, Name: file + suffix, Stage: stage, Reason: reason, SourceDir: absdir, ManifestID: id, Time: time.Now()}
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	reportpath := filepath.Join(qdir, r.Name+reportsuffix)
+	if err = os.WriteFile(reportpath, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(filepath.Join(srcdir, file), filepath.Join(qdir, r.Name)); err != nil {
+		os.Remove(reportpath)
+		return err
+	}
+	return nil
+}
+
+// sortable by quarantining time
+func uniqueSuffix() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "." + time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// reports of quarantined files in all stages' dirs in basepath
+func List(basepath string) ([]*Report, error) {
+	stages, err := os.ReadDir(basepath)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	for _, st := range stages {
+		if !st.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(StageDir(basepath, st.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), reportsuffix) {
+				continue
+			}
+			r, err := readReport(filepath.Join(basepath, st.Name(), f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, r)
+		}
+	}
+	return reports, nil
+}
+
+// moves quarantined files with given names (original or quarantined ones, all if no names given) back
+// to their source dirs and returns them to their manifest entries. Source dir is locked while file is moved,
+// existing file in it is not overwritten (ErrExists is returned). Returns requeued reports, stops on first error
+func Requeue(ctx context.Context, mf *manifest.Manifest, basepath string, names ...string) ([]*Report, error) {
+	reports, err := List(basepath)
+	if err != nil {
+		return nil, err
+	}
+	var requeued []*Report
+	for _, r := range reports {
+		if len(names) > 0 && !contains(names, r.File) && !contains(names, r.Name) {
+			continue
+		}
+		if err = requeue(ctx, r, StageDir(basepath, r.Stage)); err != nil {
+			return requeued, err
+		}
+		if err = mf.Requeue(ctx, r.ManifestID, r.File, r.Stage); err != nil {
+			return requeued, err
+		}
+		requeued = append(requeued, r)
+	}
+	return requeued, nil
+}
+
+func requeue(ctx context.Context, r *Report, qdir string) error {
+	if err := locker.LockDirContext(ctx, r.SourceDir, nil); err != nil {
+		return err
+	}
+	defer locker.UnlockDir(r.SourceDir)
+	target := filepath.Join(r.SourceDir, r.File)
+	// link fails on existing target, unlike rename
+	if err := os.Link(filepath.Join(qdir, r.Name), target); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrExists
+		}
+		return err
+	}
+	if err := os.Remove(filepath.Join(qdir, r.Name)); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return os.Remove(filepath.Join(qdir, r.Name+reportsuffix))
+}
+
+func readReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	if err = json.Unmarshal(data, r); err != nil {
+		return nil, errors.New("decode report " + path + " err: " + err.Error())
+	}
+	if r.Name == "" { // quarantined without suffix
+		r.Name = r.File
+	}
+	return r, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
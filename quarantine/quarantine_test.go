@@ -0,0 +1,116 @@
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/manifest"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	srcdir, basepath := t.TempDir(), t.TempDir()
+	mf, err := manifest.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two files with the same name fail one after another
+	var ids []string
+	for _, data := range []string{"first", "second"} {
+		if err = os.WriteFile(filepath.Join(srcdir, "price.csv"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		id, err := mf.Receive(ctx, "price.csv", "format", "sup", manifest.Source{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		if err = Put(ctx, mf, basepath, srcdir, "price.csv", "format", "bad "+data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(srcdir, "price.csv")); !os.IsNotExist(err) {
+			t.Fatal("file is left in source dir")
+		}
+		if e, err := mf.Get(id); err != nil || e.State != manifest.Failed {
+			t.Fatalf("entry %+v, %v, want failed", e, err)
+		}
+	}
+
+	reports, err := List(basepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Name == reports[1].Name {
+		t.Fatalf("reports %+v, want two with different names", reports)
+	}
+	for _, r := range reports {
+		if r.File != "price.csv" || !strings.HasPrefix(r.Name, "price.csv.") || r.Stage != "format" || r.SourceDir != srcdir {
+			t.Fatalf("report %+v", r)
+		}
+		if _, err = os.Stat(filepath.Join(StageDir(basepath, "format"), r.Name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// source dir is locked
+	if err = locker.LockDir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond*300)
+	requeued, err := Requeue(tctx, mf, basepath, reports[0].Name)
+	cancel()
+	if err == nil || len(requeued) != 0 {
+		t.Fatalf("Requeue() into locked dir = %v, %v", requeued, err)
+	}
+	if err = locker.UnlockDir(srcdir); err != nil {
+		t.Fatal(err)
+	}
+
+	// first one is requeued, second one doesn't overwrite it
+	requeued, err = Requeue(ctx, mf, basepath, "price.csv")
+	if !errors.Is(err, ErrExists) || len(requeued) != 1 {
+		t.Fatalf("Requeue() = %v, %v, want one requeued and %v", requeued, err, ErrExists)
+	}
+	data, err := os.ReadFile(filepath.Join(srcdir, "price.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		e, err := mf.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requeued[0].ManifestID == id {
+			if e.State != manifest.Requeued || len(e.Files) != 1 || e.Files[0] != "price.csv" {
+				t.Fatalf("requeued entry %+v", e)
+			}
+			if want := []string{"first", "second"}[i]; string(data) != want {
+				t.Fatalf("requeued file %q, want %q", data, want)
+			}
+		} else if e.State != manifest.Failed {
+			t.Fatalf("not requeued entry %+v", e)
+		}
+	}
+	if reports, err = List(basepath); err != nil || len(reports) != 1 || reports[0].Name == requeued[0].Name {
+		t.Fatalf("List() after requeue = %+v, %v", reports, err)
+	}
+}
+
+func TestPutWithoutBasepath(t *testing.T) {
+	srcdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcdir, "price.csv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Put(context.Background(), nil, "", srcdir, "price.csv", "format", "bad"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(srcdir, "price.csv")); err != nil {
+		t.Fatal("file is not left in source dir")
+	}
+}
@@ -0,0 +1,366 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+	"github.com/okonma-violet/spec/quarantine"
+	"github.com/okonma-violet/spec/watcher"
+)
+
+// extract stage unzips archives from zip dir, convert stage converts xls files into csv with soffice.
+// Both move csv files into csv dir
+
+const UnzipPath = "./unzipped/"
+
+const stagename = "unzipper"
+
+var lockopts = &locker.Options{Timeout: time.Second * 15, MinBackoff: time.Millisecond * 500, MaxBackoff: time.Second * 5}
+
+type Config struct {
+	ZipPath         string
+	CsvPath         string
+	ShittyCharsets  []ShittyCharsetZip
+	RemoveProcessed bool
+	Watcher         *watcher.Watcher   // ZipPath's watcher, nil in timer mode
+	Manifest        *manifest.Manifest // nil if provenance is not tracked
+	QuarantinePath  string             // failed files are moved into its stage's dir, if set
+}
+
+// zips with names starting with prefix are unzipped with charset
+type ShittyCharsetZip struct {
+	Prefix  string
+	Charset string
+}
+
+// returns nil, if dirs were not locked. Lease is renewed, as unzipping and converting may take long
+func (conf *Config) lockdirs(ctx context.Context, l logger.Logger) *locker.Lease {
+	lockstart := time.Now()
+	lease, err := locker.LockDirsLease(ctx, lockopts, conf.ZipPath, conf.CsvPath)
+	metrics.LockWait.Since(lockstart, stagename)
+	if err != nil {
+		if errors.Is(err, locker.ErrLocked) {
+			l.Error("LockDir", errors.New("zip or csv dir locked, timeout reached"))
+		} else {
+			l.Error("LockDir", err)
+		}
+		return nil
+	}
+	return lease
+}
+
+// checked before every file, so files are not touched after lock was lost
+func lost(l logger.Logger, lease *locker.Lease) bool {
+	select {
+	case <-lease.Lost():
+		l.Error("Lease", errors.New("lock lost, stopping: "+lease.Check().Error()))
+		return true
+	default:
+		return false
+	}
+}
+
+// unzips zips from ZipPath into UnzipPath and moves csvs from ZipPath into CsvPath.
+// Returns number of processed files
+func (conf *Config) Extract(ctx context.Context, l logger.Logger) int {
+	defer metrics.JobDuration.Since(time.Now(), stagename)
+	lease := conf.lockdirs(ctx, l)
+	if lease == nil {
+		return 0
+	}
+	defer lease.Unlock()
+
+	l.Debug("ZipDir_Loop", "started")
+	files, err := os.ReadDir(conf.ZipPath)
+	if err != nil {
+		l.Error("ZipDir_Loop/ReadDir", err)
+		return 0
+	}
+
+	var processed int
+	for _, f := range files {
+		if lost(l, lease) {
+			return processed
+		}
+		fname_lowered := strings.ToLower(f.Name())
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") { // lockfiles
+			continue
+		}
+		if !conf.Watcher.Ready(f) {
+			l.Debug("ZipDir_Loop", "file is not ready yet, skipped: "+f.Name())
+			continue
+		}
+
+		if strings.HasSuffix(fname_lowered, ".zip") {
+			before, err := dirnames(UnzipPath)
+			if err != nil {
+				l.Error("Unzip/ReadDir", err)
+				continue
+			}
+			charset := ""
+			for i := 0; i < len(conf.ShittyCharsets); i++ {
+				if strings.HasPrefix(fname_lowered, conf.ShittyCharsets[i].Prefix) {
+					charset = conf.ShittyCharsets[i].Charset
+					break
+				}
+			}
+			var out string
+			if charset != "" {
+				out, err = unzip_with_charset(conf.ZipPath+f.Name(), charset, UnzipPath)
+			} else {
+				out, err = unzip(conf.ZipPath+f.Name(), UnzipPath)
+			}
+			if err != nil {
+				l.Error("Unzip", errors.New(err.Error()+" \nout: "+out))
+				conf.fail(ctx, l, conf.ZipPath, f.Name(), "unzip: "+err.Error())
+				continue
+			}
+			l.Debug("Unzip", "unzipped "+f.Name())
+			conf.transitExtracted(ctx, l, f.Name(), before)
+			goto remove
+		}
+
+		if strings.HasSuffix(f.Name(), ".csv") {
+			if err = os.Rename(conf.ZipPath+f.Name(), conf.CsvPath+f.Name()); err != nil {
+				l.Error("MoveCsv/Rename", err)
+				continue
+			}
+			l.Debug("MoveCsv", "moved "+f.Name())
+			conf.transit(ctx, l, f.Name(), manifest.Extracted)
+			metrics.FilesProcessed.Inc(stagename, "")
+			processed++
+			continue
+		}
+		// xls files are left for convert stage
+		if strings.Contains(fname_lowered, ".xls") {
+			continue
+		}
+		l.Warning("ZipDir_Loop", "nondir/nonzip/noncsv/nonxls file found: "+f.Name())
+		continue
+	remove:
+		metrics.FilesProcessed.Inc(stagename, "")
+		processed++
+		if conf.RemoveProcessed {
+			if err = os.Remove(conf.ZipPath + f.Name()); err != nil {
+				l.Error("ZipDir_Loop/Remove", err)
+			}
+			l.Debug("ZipDir_Loop", "removed "+f.Name())
+		}
+	}
+	l.Debug("ZipDir_Loop", "done")
+	return processed
+}
+
+// converts xls files from ZipPath and UnzipPath into csv and moves csvs from UnzipPath into CsvPath.
+// Returns number of processed files
+func (conf *Config) Convert(ctx context.Context, l logger.Logger) int {
+	defer metrics.JobDuration.Since(time.Now(), stagename)
+	lease := conf.lockdirs(ctx, l)
+	if lease == nil {
+		return 0
+	}
+	defer lease.Unlock()
+
+	var processed int
+	l.Debug("ZipDir_Loop", "started")
+	files, err := os.ReadDir(conf.ZipPath)
+	if err != nil {
+		l.Error("ZipDir_Loop/ReadDir", err)
+		return 0
+	}
+	for _, f := range files {
+		if lost(l, lease) {
+			return processed
+		}
+		if f.IsDir() || !strings.Contains(strings.ToLower(f.Name()), ".xls") {
+			continue
+		}
+		if !conf.Watcher.Ready(f) {
+			l.Debug("ZipDir_Loop", "file is not ready yet, skipped: "+f.Name())
+			continue
+		}
+		if out, err := converttocsv(conf.ZipPath + f.Name()); err != nil {
+			l.Error("ConvertToCsv", errors.New(err.Error()+" \nout: "+out))
+			conf.fail(ctx, l, conf.ZipPath, f.Name(), "convert: "+err.Error())
+			continue
+		}
+		l.Debug("ConvertToCsv", "converted "+f.Name())
+		conf.transit(ctx, l, f.Name(), manifest.Converted, convertedname(f.Name()))
+		metrics.FilesProcessed.Inc(stagename, "")
+		processed++
+		if conf.RemoveProcessed {
+			if err = os.Remove(conf.ZipPath + f.Name()); err != nil {
+				l.Error("ZipDir_Loop/Remove", err)
+			}
+			l.Debug("ZipDir_Loop", "removed "+f.Name())
+		}
+	}
+	l.Debug("ZipDir_Loop", "done")
+
+	l.Debug("UnzippedDir_Loop", "started")
+	files, err = os.ReadDir(UnzipPath)
+	if err != nil {
+		l.Error("UnzippedDir_Loop/ReadDir", err)
+		return processed
+	}
+	for _, f := range files {
+		if lost(l, lease) {
+			return processed
+		}
+		fname_lowered := strings.ToLower(f.Name())
+		if f.IsDir() {
+			continue
+		}
+
+		if strings.Contains(fname_lowered, ".xls") {
+			if out, err := converttocsv(UnzipPath + f.Name()); err != nil {
+				l.Error("ConvertToCsv", errors.New(err.Error()+" \nout: "+out))
+				conf.fail(ctx, l, UnzipPath, f.Name(), "convert: "+err.Error())
+				continue
+			}
+			l.Debug("ConvertToCsv", "converted "+f.Name())
+			conf.transit(ctx, l, f.Name(), manifest.Converted, convertedname(f.Name()))
+			goto remove2
+		}
+
+		if strings.HasSuffix(f.Name(), ".csv") {
+			if err = os.Rename(UnzipPath+f.Name(), conf.CsvPath+f.Name()); err != nil {
+				l.Error("MoveCsv/Rename", err)
+				continue
+			}
+			l.Debug("MoveCsv", "moved "+f.Name())
+			processed++
+			continue
+		}
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		l.Warning("UnzippedDir_Loop", "nondir/noncsv/nonxls file found: "+f.Name())
+		continue
+	remove2:
+		metrics.FilesProcessed.Inc(stagename, "")
+		processed++
+		if conf.RemoveProcessed {
+			if err = os.Remove(UnzipPath + f.Name()); err != nil {
+				l.Error("UnzippedDir_Loop/Remove", err)
+			}
+			l.Debug("UnzippedDir_Loop", "removed "+f.Name())
+		}
+	}
+	l.Debug("UnzippedDir_Loop", "done")
+
+	l.Debug("ConvertedToCsvDir_Loop", "started")
+	files, err = os.ReadDir(".")
+	if err != nil {
+		l.Error("ConvertedToCsvDir_Loop/ReadDir", err)
+		return processed
+	}
+	for _, f := range files {
+		fname_lowered := strings.ToLower(f.Name())
+		if f.IsDir() {
+			continue
+		}
+		if !f.IsDir() && strings.HasSuffix(fname_lowered, ".csv") {
+			if err = os.Rename(f.Name(), conf.CsvPath+f.Name()); err != nil {
+				l.Error("Movecsv/Rename", err)
+				continue
+			}
+			l.Debug("Movecsv", "moved "+f.Name())
+			processed++
+		}
+	}
+	l.Debug("ConvertedToCsvDir_Loop", "done")
+	return processed
+}
+
+func (conf *Config) transit(ctx context.Context, l logger.Logger, file string, state manifest.State, newfiles ...string) {
+	if err := conf.Manifest.Transit(ctx, file, state, stagename, newfiles...); err != nil {
+		l.Error("Manifest.Transit", err)
+	}
+}
+
+// fails file's manifest entry and moves file from dir into stage's quarantine dir
+func (conf *Config) fail(ctx context.Context, l logger.Logger, dir, file string, reason string) {
+	if err := quarantine.Put(ctx, conf.Manifest, conf.QuarantinePath, dir, file, stagename, reason); err != nil {
+		l.Error("Quarantine", err)
+		return
+	}
+	if conf.QuarantinePath != "" {
+		l.Warning("Quarantine", "file moved into quarantine: "+file+", reason: "+reason)
+	}
+}
+
+// files, that appeared in UnzipPath since before, are the archive's ones.
+// Files, that were left in UnzipPath and were overwritten, can't be told apart
+func (conf *Config) transitExtracted(ctx context.Context, l logger.Logger, zipname string, before map[string]bool) {
+	if conf.Manifest == nil {
+		return
+	}
+	after, err := dirnames(UnzipPath)
+	if err != nil {
+		l.Error("Unzip/ReadDir", err)
+		return
+	}
+	var extracted []string
+	for name := range after {
+		if !before[name] {
+			extracted = append(extracted, name)
+		}
+	}
+	if len(extracted) == 0 {
+		if err = conf.Manifest.Fail(ctx, zipname, stagename, "no new files extracted"); err != nil {
+			l.Error("Manifest.Fail", err)
+		}
+		return
+	}
+	conf.transit(ctx, l, zipname, manifest.Extracted, extracted...)
+}
+
+func dirnames(path string) (map[string]bool, error) {
+	files, err := os.ReadDir(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	names := make(map[string]bool, len(files))
+	for _, f := range files {
+		if !f.IsDir() {
+			names[f.Name()] = true
+		}
+	}
+	return names, nil
+}
+
+// name of csv, made by soffice from xls file
+func convertedname(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".csv"
+}
+
+func run(path string, args []string) (out string, err error) {
+
+	cmd := exec.Command(path, args...)
+
+	var b []byte
+	b, err = cmd.CombinedOutput()
+	out = string(b)
+
+	return
+}
+
+func unzip(filename, dir string) (string, error) {
+	return run("unzip", []string{"-o", filename, "-d", dir})
+}
+func unzip_with_charset(filename, charset, dir string) (string, error) {
+	return run("unzip", []string{"-O", charset, "-o", filename, "-d", dir})
+}
+func converttocsv(filename string) (string, error) {
+	return run("soffice", []string{"--headless", "--convert-to", "csv", "--infilter=CSV:44,34,76,1", filename})
+}
@@ -2,83 +2,103 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
+	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
 
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/okonma-violet/confdecoder"
-	"github.com/okonma-violet/spec/locker"
+	"github.com/okonma-violet/spec/config"
 	"github.com/okonma-violet/spec/logs/encode"
 	"github.com/okonma-violet/spec/logs/logger"
+	"github.com/okonma-violet/spec/manifest"
+	"github.com/okonma-violet/spec/metrics"
+	"github.com/okonma-violet/spec/quarantine"
+	"github.com/okonma-violet/spec/unzipper/extract"
+	"github.com/okonma-violet/spec/watcher"
 )
 
-type config struct {
-	ZipPath      string
-	CsvPath      string
-	TimerSeconds int
-
-	ShittyCharsetZipNamesPrefixes []string
-	ShittyCharsets                []string
-}
-
-type shittycharsetzip struct {
-	prefix  string
-	charset string
-}
-
-const waitdirlock_time = time.Second * 5
-const maxwaittimes = 3
-const unzippath = "./unzipped/"
-
 func main() {
-	conf := &config{}
-	err := confdecoder.DecodeFile("config.txt", conf)
+	conf, common := &config.Unzipper{}, &config.Common{}
+	err := config.Load("config.txt", "UNZIPPER", conf, common)
 	if err != nil {
-		panic("read config file err: " + err.Error())
+		panic(err.Error())
 	}
-	if conf.ZipPath == "" {
-		panic("no ZipPath specified in config.txt")
-	}
-	if conf.CsvPath == "" {
-		panic("no CsvPath specified in config.txt")
-	}
-	if conf.TimerSeconds == 0 {
-		panic("no TimerSeconds specified in config.txt or is zero")
-	}
-	if len(conf.ShittyCharsetZipNamesPrefixes) != len(conf.ShittyCharsets) {
-		panic("lengths mismatch of ShittyCharsetZipNamesPrefixes with ShittyCharsets in config.txt")
-	}
-	conf.ZipPath += "/"
-	conf.CsvPath += "/"
 
 	rp := flag.Bool("r", false, "remove processed zip and xls files")
+	wt := flag.Bool("w", false, "watch ZipPath with inotify, timer is a fallback")
 
 	flag.Parse()
 
-	shittycharsets := make([]shittycharsetzip, 0, len(conf.ShittyCharsetZipNamesPrefixes))
+	ec := &extract.Config{ZipPath: conf.ZipPath, CsvPath: conf.CsvPath, RemoveProcessed: *rp}
 	for i := 0; i < len(conf.ShittyCharsetZipNamesPrefixes); i++ {
-		shittycharsets = append(shittycharsets, shittycharsetzip{prefix: strings.ToLower(conf.ShittyCharsetZipNamesPrefixes[i]), charset: conf.ShittyCharsets[i]})
+		ec.ShittyCharsets = append(ec.ShittyCharsets, extract.ShittyCharsetZip{Prefix: strings.ToLower(conf.ShittyCharsetZipNamesPrefixes[i]), Charset: conf.ShittyCharsets[i]})
 	}
 
 	ctx, _ := createContextWithInterruptSignal()
 
-	flsh := logger.NewFlusher(encode.DebugLevel)
+	sinks, err := common.LogsSinks()
+	if err != nil {
+		panic(err.Error())
+	}
+	flsh := logger.NewFlusher(encode.DebugLevel, sinks...)
+	if common.LogsLevelsPath != "" {
+		if err := flsh.ReloadLevelsOnSighup(common.LogsLevelsPath); err != nil {
+			panic("load logs levels err: " + err.Error())
+		}
+	}
 	l := flsh.NewLogsContainer("unzipper")
+	if common.MetricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, common.MetricsAddr); err != nil {
+				l.Error("metrics.Serve", err)
+			}
+		}()
+	}
 	if *rp {
 		l.Info("Flag", "removing processed files enabled")
 	}
+	var mf *manifest.Manifest
+	if common.ManifestPath != "" {
+		if mf, err = manifest.Open(common.ManifestPath); err != nil {
+			panic("open manifest err: " + err.Error())
+		}
+		ec.Manifest = mf
+	}
+	ec.QuarantinePath = common.QuarantinePath
+	if flag.Arg(0) == "requeue" {
+		if common.QuarantinePath == "" {
+			panic("no QuarantinePath specified in config.txt")
+		}
+		requeued, err := quarantine.Requeue(ctx, mf, common.QuarantinePath, flag.Args()[1:]...)
+		for _, r := range requeued {
+			fmt.Println("requeued " + r.File + " into " + r.SourceDir)
+		}
+		if err != nil {
+			panic("requeue err: " + err.Error())
+		}
+		return
+	}
+	var events <-chan struct{} // nil in timer mode
+	if *wt {
+		w, err := watcher.New(conf.ZipPath, watcher.DefaultQuiescence)
+		if err != nil {
+			panic("watch ZipPath err: " + err.Error())
+		}
+		defer w.Close()
+		ec.Watcher, events = w, w.Events()
+		l.Info("Flag", "watching ZipPath enabled")
+	}
 
 	go func() {
 		l.Info("Routine", "loop started")
 		ticker := time.NewTicker(time.Second * time.Duration(conf.TimerSeconds))
 		l.Debug("Job", "started")
-		do_job(l, *rp, conf, shittycharsets)
+		ec.Extract(ctx, l)
+		ec.Convert(ctx, l)
 		l.Debug("Job", "done, sleeping")
 
 		for {
@@ -87,11 +107,13 @@ func main() {
 				l.Info("Routine", "context done, exiting loop")
 				return
 			case <-ticker.C:
-				l.Debug("Job", "started")
-				do_job(l, *rp, conf, shittycharsets)
-				l.Debug("Job", "done, sleeping")
+			case <-events:
+				l.Debug("Watcher", "new files are ready")
 			}
-
+			l.Debug("Job", "started")
+			ec.Extract(ctx, l)
+			ec.Convert(ctx, l)
+			l.Debug("Job", "done, sleeping")
 		}
 	}()
 
@@ -101,180 +123,6 @@ func main() {
 	flsh.DoneWithTimeout(time.Second * 5)
 }
 
-func do_job(l logger.Logger, remove_processed bool, conf *config, shittyzips []shittycharsetzip) {
-	l.Debug("ZipDir_Loop", "started")
-
-	var sucs bool
-	for i := 0; i < maxwaittimes; i++ {
-		if err := locker.LockDir(conf.ZipPath); err != nil {
-			if errors.Is(err, locker.ErrLocked) {
-				l.Error("LockDir", errors.New("zip dir locked"))
-				time.Sleep(waitdirlock_time)
-			} else {
-				l.Error("LockDir", err)
-				return
-			}
-		} else {
-			sucs = true
-			break
-		}
-	}
-	if !sucs {
-		l.Error("LockDir", errors.New("tries over, returning"))
-		return
-	}
-	defer locker.UnlockDir(conf.ZipPath)
-	sucs = false
-
-	for i := 0; i < maxwaittimes; i++ {
-		if err := locker.LockDir(conf.CsvPath); err != nil {
-			if errors.Is(err, locker.ErrLocked) {
-				l.Error("LockDir", errors.New("csv dir locked"))
-				time.Sleep(waitdirlock_time)
-			} else {
-				l.Error("LockDir", err)
-				return
-			}
-		} else {
-			sucs = true
-			break
-		}
-	}
-	if !sucs {
-		l.Error("LockDir", errors.New("tries over, returning"))
-		return
-	}
-	defer locker.UnlockDir(conf.CsvPath)
-
-	files, err := os.ReadDir(conf.ZipPath)
-	if err != nil {
-		l.Error("ZipDir_Loop/ReadDir", err)
-		return
-	}
-
-	for _, f := range files {
-		fname_lowered := strings.ToLower(f.Name())
-		if f.IsDir() {
-			continue
-		}
-
-		if strings.HasSuffix(fname_lowered, ".zip") {
-			for i := 0; i < len(shittyzips); i++ {
-				if strings.HasPrefix(fname_lowered, shittyzips[i].prefix) {
-					if out, err := unzip_with_charset(conf.ZipPath+f.Name(), shittyzips[i].charset, unzippath); err != nil {
-						l.Error("Unzip", errors.New(err.Error()+", out: "+out))
-						continue
-					}
-					l.Debug("Unzip", "unzipped "+f.Name())
-					goto remove
-				}
-			}
-			if out, err := unzip(conf.ZipPath+f.Name(), unzippath); err != nil {
-				l.Error("Unzip", errors.New(err.Error()+" \nout: "+out))
-				continue
-			}
-			l.Debug("Unzip", "unzipped "+f.Name())
-			goto remove
-		}
-
-		if strings.Contains(fname_lowered, ".xls") {
-			if out, err := converttocsv(conf.ZipPath + f.Name()); err != nil {
-				l.Error("ConvertToCsv", errors.New(err.Error()+" \nout: "+out))
-				continue
-			}
-			l.Debug("ConvertToCsv", "converted "+f.Name())
-			goto remove
-		}
-
-		if strings.HasSuffix(f.Name(), ".csv") {
-			if err = os.Rename(conf.ZipPath+f.Name(), conf.CsvPath+f.Name()); err != nil {
-				l.Error("MoveCsv/Rename", err)
-				continue
-			}
-			l.Debug("MoveCsv", "moved "+f.Name())
-			continue
-		}
-		if f.Name() == locker.LockfileName {
-			continue
-		}
-		l.Warning("ZipDir_Loop", "nondir/nonzip/noncsv/nonxls file found: "+f.Name())
-		continue
-	remove:
-		if remove_processed {
-			if err = os.Remove(conf.ZipPath + f.Name()); err != nil {
-				l.Error("ZipDir_Loop/Remove", err)
-			}
-			l.Debug("ZipDir_Loop", "removed "+f.Name())
-		}
-	}
-	l.Debug("ZipDir_Loop", "done")
-
-	l.Debug("UnzippedDir_Loop", "started")
-	files, err = os.ReadDir(unzippath)
-	if err != nil {
-		l.Error("UnzippedDir_Loop/ReadDir", err)
-		return
-	}
-	for _, f := range files {
-		fname_lowered := strings.ToLower(f.Name())
-		if f.IsDir() {
-			continue
-		}
-
-		if strings.Contains(fname_lowered, ".xls") {
-			if out, err := converttocsv(unzippath + f.Name()); err != nil {
-				l.Error("ConvertToCsv", errors.New(err.Error()+" \nout: "+out))
-				continue
-			}
-			l.Debug("ConvertToCsv", "converted "+f.Name())
-			goto remove2
-		}
-
-		if strings.HasSuffix(f.Name(), ".csv") {
-			if err = os.Rename(unzippath+f.Name(), conf.CsvPath+f.Name()); err != nil {
-				l.Error("MoveCsv/Rename", err)
-				continue
-			}
-			l.Debug("MoveCsv", "moved "+f.Name())
-			continue
-		}
-		if f.Name() == locker.LockfileName {
-			continue
-		}
-		l.Warning("UnzippedDir_Loop", "nondir/noncsv/nonxls file found: "+f.Name())
-		continue
-	remove2:
-		if remove_processed {
-			if err = os.Remove(unzippath + f.Name()); err != nil {
-				l.Error("UnzippedDir_Loop/Remove", err)
-			}
-			l.Debug("UnzippedDir_Loop", "removed "+f.Name())
-		}
-	}
-	l.Debug("UnzippedDir_Loop", "done")
-
-	l.Debug("ConvertedToCsvDir_Loop", "started")
-	files, err = os.ReadDir(".")
-	if err != nil {
-		l.Error("ConvertedToCsvDir_Loop/ReadDir", err)
-		return
-	}
-	for _, f := range files {
-		fname_lowered := strings.ToLower(f.Name())
-		if f.IsDir() {
-			continue
-		}
-		if !f.IsDir() && strings.HasSuffix(fname_lowered, ".csv") {
-			if err = os.Rename(f.Name(), conf.CsvPath+f.Name()); err != nil {
-				l.Error("Movecsv/Rename", err)
-				continue
-			}
-			l.Debug("Movecsv", "moved "+f.Name())
-		}
-	}
-	l.Debug("ConvertedToCsvDir_Loop", "done")
-}
-
 func createContextWithInterruptSignal() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	stop := make(chan os.Signal, 1)
@@ -286,23 +134,3 @@ func createContextWithInterruptSignal() (context.Context, context.CancelFunc) {
 	}()
 	return ctx, cancel
 }
-func run(path string, args []string) (out string, err error) {
-
-	cmd := exec.Command(path, args...)
-
-	var b []byte
-	b, err = cmd.CombinedOutput()
-	out = string(b)
-
-	return
-}
-
-func unzip(filename, dir string) (string, error) {
-	return run("unzip", []string{"-o", filename, "-d", dir})
-}
-func unzip_with_charset(filename, charset, dir string) (string, error) {
-	return run("unzip", []string{"-O", charset, "-o", filename, "-d", dir})
-}
-func converttocsv(filename string) (string, error) {
-	return run("soffice", []string{"--headless", "--convert-to", "csv", "--infilter=CSV:44,34,76,1", filename})
-}
@@ -0,0 +1,165 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watches dir with inotify and reports files, that were closed after writing (or moved into dir)
+// and then were not changed for quiescence period. Files, that are left open, are reported, when
+// they were not modified for quiescence period. Dot files (e.g. lockfiles) are ignored
+type Watcher struct {
+	dir        string
+	quiescence time.Duration
+	file       *os.File
+	events     chan struct{}
+	done       chan struct{}
+
+	mux   sync.Mutex
+	files map[string]*filestate // changed files, that are not ready yet
+}
+
+type filestate struct {
+	writing bool
+	changed time.Time
+}
+
+const DefaultQuiescence = time.Second * 2
+
+const watchmask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+func New(dirpath string, quiescence time.Duration) (*Watcher, error) {
+	if quiescence <= 0 {
+		quiescence = DefaultQuiescence
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err = syscall.InotifyAddWatch(fd, dirpath, watchmask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	w := &Watcher{
+		dir:        dirpath,
+		quiescence: quiescence,
+		file:       os.NewFile(uintptr(fd), "inotify:"+dirpath),
+		events:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		files:      make(map[string]*filestate),
+	}
+	go w.readEvents()
+	go w.checkQuiescence()
+	return w, nil
+}
+
+// signalled when some files became ready (or when events were lost and dir must be reread)
+func (w *Watcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+// file is ready when it is not being written and was not changed for quiescence period.
+// nil watcher (timer mode) checks only file's modification time
+func (w *Watcher) Ready(f os.DirEntry) bool {
+	quiescence := DefaultQuiescence
+	if w != nil {
+		quiescence = w.quiescence
+		w.mux.Lock()
+		_, pending := w.files[f.Name()]
+		w.mux.Unlock()
+		if pending {
+			return false
+		}
+	}
+	info, err := f.Info()
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) >= quiescence
+}
+
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *Watcher) readEvents() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		w.mux.Lock()
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			namestart := off + syscall.SizeofInotifyEvent
+			off = namestart + int(ev.Len)
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.notify()
+				continue
+			}
+			if ev.Mask&syscall.IN_ISDIR != 0 || off > n {
+				continue
+			}
+			name := strings.TrimRight(string(buf[namestart:off]), "\x00")
+			if name == "" || strings.HasPrefix(name, ".") {
+				continue
+			}
+			switch {
+			case ev.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+				delete(w.files, name)
+			case ev.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+				w.files[name] = &filestate{changed: now}
+			default: // created or modified
+				w.files[name] = &filestate{writing: true, changed: now}
+			}
+		}
+		w.mux.Unlock()
+	}
+}
+
+func (w *Watcher) checkQuiescence() {
+	ticker := time.NewTicker(w.quiescence / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case now := <-ticker.C:
+			var ready bool
+			w.mux.Lock()
+			for name, st := range w.files {
+				if now.Sub(st.changed) < w.quiescence {
+					continue
+				}
+				// writer may never close file (e.g. it was killed), so file, that is not modified
+				// for quiescence period, is ready too
+				if st.writing {
+					if info, err := os.Stat(filepath.Join(w.dir, name)); err == nil && now.Sub(info.ModTime()) < w.quiescence {
+						continue
+					}
+				}
+				delete(w.files, name)
+				ready = true
+			}
+			w.mux.Unlock()
+			if ready {
+				w.notify()
+			}
+		}
+	}
+}
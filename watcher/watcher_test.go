@@ -0,0 +1,162 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const quiescence = time.Millisecond * 200
+
+func entry(t *testing.T, dir, name string) os.DirEntry {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == name {
+			return e
+		}
+	}
+	t.Fatal("no file " + name)
+	return nil
+}
+
+// waits for event, that must not come before quiescence period after start
+func waitEvent(t *testing.T, w *Watcher, start time.Time) {
+	t.Helper()
+	select {
+	case <-w.Events():
+		if since := time.Since(start); since < quiescence {
+			t.Fatalf("event after %v, before quiescence period", since)
+		}
+	case <-time.After(quiescence * 10):
+		t.Fatal("no event")
+	}
+}
+
+func noEvent(t *testing.T, w *Watcher) {
+	t.Helper()
+	select {
+	case <-w.Events():
+		t.Fatal("unexpected event")
+	case <-time.After(quiescence * 2):
+	}
+}
+
+func TestClosedFile(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(dir, quiescence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	f, err := os.Create(filepath.Join(dir, "price.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString("data"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(quiescence / 2)
+	if w.Ready(entry(t, dir, "price.csv")) {
+		t.Fatal("file being written is ready")
+	}
+	f.Close()
+	waitEvent(t, w, time.Now())
+	if !w.Ready(entry(t, dir, "price.csv")) {
+		t.Fatal("file is not ready after event")
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(dir, quiescence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// writer never closes file
+	f, err := os.Create(filepath.Join(dir, "price.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("data")
+	// modifications postpone readiness
+	var start time.Time
+	for i := 0; i < 3; i++ {
+		time.Sleep(quiescence / 2)
+		f.WriteString("data")
+		start = time.Now()
+	}
+	waitEvent(t, w, start)
+	if !w.Ready(entry(t, dir, "price.csv")) {
+		t.Fatal("file is not ready after event")
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(dir, quiescence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err = os.WriteFile(filepath.Join(dir, ".lock"), []byte("pid 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	noEvent(t, w)
+	// deleted before quiescence period
+	if err = os.WriteFile(filepath.Join(dir, "tmp.csv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "tmp.csv")); err != nil {
+		t.Fatal(err)
+	}
+	noEvent(t, w)
+}
+
+func TestMovedIn(t *testing.T) {
+	dir, src := t.TempDir(), t.TempDir()
+	w, err := New(dir, quiescence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err = os.WriteFile(filepath.Join(src, "price.csv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filepath.Join(src, "price.csv"), filepath.Join(dir, "price.csv")); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, w, time.Now())
+}
+
+func TestReadyWithoutWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "price.csv")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var w *Watcher
+	if w.Ready(entry(t, dir, "price.csv")) {
+		t.Fatal("just written file is ready")
+	}
+	old := time.Now().Add(-DefaultQuiescence * 2)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Ready(entry(t, dir, "price.csv")) {
+		t.Fatal("old file is not ready")
+	}
+}